@@ -9,10 +9,15 @@ import (
 	"syscall"
 
 	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
 	"github.com/netfoundry/workspace-agent/harness/internal/manager"
 	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/privilege"
+	"github.com/netfoundry/workspace-agent/harness/internal/proxy"
 	"github.com/netfoundry/workspace-agent/harness/internal/resources"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
 	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/supervisor"
 	"github.com/netfoundry/workspace-agent/harness/internal/tui"
 	"github.com/netfoundry/workspace-agent/harness/internal/web"
 )
@@ -36,6 +41,12 @@ func main() {
 	if err != nil {
 		logger.Fatal("Failed to load config", "path", *configPath, "error", err)
 	}
+	switch {
+	case !cfg.Access.Enforced():
+		logger.Warn("ACCESS IS OPEN: no roles are configured in workspace.yaml, so everyone who can reach Mattermost or the web UI is a director")
+	case len(cfg.Access.TrustedProxies) == 0:
+		logger.Warn("No access.trusted_proxies configured: web UI identity headers are ignored and every web request will be refused")
+	}
 
 	// Initialize shared state
 	appState := state.New(cfg)
@@ -73,12 +84,51 @@ func main() {
 		go mmBridge.Run(ctx)
 	}
 
+	// Start worker spawner
+	workerSpawner := spawner.New(appState, mmBridge, logger)
+	go workerSpawner.Run(ctx)
+
+	// Director approvals asked in task threads
+	approvals := approval.NewBroker(appState, mmBridge, logger)
+
+	// Start stuck-worker and token budget supervisor
+	workerSupervisor := supervisor.New(appState, workerSpawner, approvals, mmBridge, logger)
+	go workerSupervisor.Run(ctx)
+
+	// Privilege requests from workers, decided by the director
+	privileges := privilege.New(appState, workerSpawner, approvals, mmBridge, logger)
+	privileges.SetContext(ctx)
+
 	// Start manager lifecycle
-	mgr := manager.New(appState, mmBridge, logger)
+	mgr := manager.New(appState, mmBridge, workerSpawner, approvals, privileges, logger)
+	privileges.SetManagerNotifier(mgr.SendMessage)
+	workerSupervisor.SetManagerNotifier(mgr.SendMessage)
 	go mgr.Run(ctx)
 
+	// Start the allowlisting egress proxy for workers
+	egressProxy := proxy.New(cfg.Egress.ProxyPort, appState, logger)
+	go egressProxy.Run(ctx)
+
 	// Start web UI
-	webServer := web.NewServer(*webPort, appState, logger)
+	webServer := web.NewServer(*webPort, appState, workerSpawner, approvals, privileges, mgr, workerSupervisor, logger)
+	// Token of the /agent slash command configured in Mattermost
+	webServer.SetSlashToken(os.Getenv("MM_SLASH_TOKEN"))
+	webServer.SetMattermost(mmBridge)
+	// The harness shares the sandbox network with workers for the egress
+	// proxy; the web API must only be reachable from the internal network.
+	// Without an internal address it stays on loopback rather than risk
+	// listening on the sandbox.
+	hostname, err := os.Hostname()
+	var internalIP string
+	if err == nil {
+		internalIP, err = spawner.NetworkIP(hostname, spawner.InternalNetwork)
+	}
+	if err == nil {
+		webServer.SetListenHosts(internalIP, "127.0.0.1")
+	} else {
+		logger.Error("Could not find the harness on the internal network; the web UI listens on 127.0.0.1 only", "error", err)
+		webServer.SetListenHosts("127.0.0.1")
+	}
 	go webServer.Run(ctx)
 
 	// Start TUI (blocks) or wait for signal
@@ -86,7 +136,7 @@ func main() {
 		logger.Info("Running headless", "web_port", *webPort, "ssh_port", *sshPort)
 		<-ctx.Done()
 	} else {
-		if err := tui.Run(appState, logger); err != nil {
+		if err := tui.Run(appState, privileges, mgr, logger); err != nil {
 			logger.Fatal("TUI error", "error", err)
 		}
 	}
@@ -0,0 +1,240 @@
+package approval
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Handler is called once the director decides on an approval
+type Handler func(a *state.Approval)
+
+// replyPattern matches in-thread decisions such as "approve", "deny a1b2c3"
+// or "approve +50000". An optional ID picks between several pending requests;
+// a bare "yes" or "no" is conversation, so those need the ID.
+var replyPattern = regexp.MustCompile(`(?i)^\s*(approve|approved|yes|deny|denied|no)\b\s*([0-9a-f]{6})?\s*(\+\s*[0-9]+[km]?)?\s*[.!]?\s*$`)
+
+// Broker asks the director for decisions in task threads and routes the
+// answers to the subsystem that asked
+type Broker struct {
+	state  *state.AppState
+	mm     *mattermost.Bridge
+	logger *log.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewBroker creates an approval broker
+func NewBroker(appState *state.AppState, mm *mattermost.Bridge, logger *log.Logger) *Broker {
+	return &Broker{
+		state:    appState,
+		mm:       mm,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for decisions on approvals of a kind
+func (b *Broker) Register(kind string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[kind] = h
+}
+
+// Request records a pending approval and posts prompt to its thread with
+// Approve and Deny buttons. The reply hint is included so the director can
+// also answer in the thread.
+func (b *Broker) Request(a *state.Approval, prompt string) error {
+	a.ID = newID()
+	a.Status = state.ApprovalPending
+	a.CreatedAt = time.Now()
+	a.Prompt = prompt
+	a.ActionToken = newActionToken()
+	b.state.AddApproval(a)
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist approval", "error", err)
+	}
+	b.logger.Info("Approval requested", "id", a.ID, "kind", a.Kind, "subject", a.Subject)
+
+	hint := "Reply `approve` or `deny`"
+	if len(b.state.PendingApprovals(a.ThreadID)) > 1 {
+		hint = fmt.Sprintf("Reply `approve %s` or `deny %s`", a.ID, a.ID)
+	}
+	if b.mm != nil && a.ThreadID != "" {
+		b.postButtons(a, hint)
+	}
+	return nil
+}
+
+// Resolve records an approval decided without asking the director, e.g. by
+// a workspace.yaml policy rule, posts note to its thread and dispatches it
+// to the kind's handler like any other decision
+func (b *Broker) Resolve(a *state.Approval, approved bool, by, note string) {
+	now := time.Now()
+	a.ID = newID()
+	a.Status = state.ApprovalDenied
+	if approved {
+		a.Status = state.ApprovalApproved
+	}
+	a.DecidedBy = by
+	a.CreatedAt = now
+	a.DecidedAt = now
+	b.state.AddApproval(a)
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist approval", "error", err)
+	}
+	b.logger.Info("Approval decided", "id", a.ID, "kind", a.Kind, "status", a.Status, "by", by, "policy", a.Policy)
+	b.mm.Notify(a.ThreadID, note)
+
+	b.mu.RLock()
+	h := b.handlers[a.Kind]
+	b.mu.RUnlock()
+	if h == nil {
+		b.logger.Warn("No handler for approval kind", "kind", a.Kind)
+		return
+	}
+	cp := *a
+	h(&cp)
+}
+
+// HandleMessage treats a thread reply as a decision on a pending approval.
+// Returns true when the message was consumed and should not be forwarded.
+func (b *Broker) HandleMessage(msg mattermost.Message) bool {
+	approved, id, amount, ok := parseReply(msg.Text)
+	if !ok {
+		return false
+	}
+	pending := b.state.PendingApprovals(msg.ThreadID)
+	if len(pending) == 0 {
+		return false
+	}
+	if !b.mm.Authorized(msg, state.RoleApprover) {
+		b.mm.Refuse(msg, "decide approval requests")
+		return true
+	}
+
+	var target *state.Approval
+	if id != "" {
+		for _, a := range pending {
+			if a.ID == id {
+				target = a
+			}
+		}
+		if target == nil {
+			b.mm.Notify(msg.ThreadID, fmt.Sprintf("No pending request `%s` in this thread.", id))
+			return true
+		}
+	} else if len(pending) == 1 {
+		target = pending[0]
+	} else {
+		ids := make([]string, len(pending))
+		for i, a := range pending {
+			ids[i] = fmt.Sprintf("`%s` (%s)", a.ID, a.Subject)
+		}
+		b.mm.Notify(msg.ThreadID, "Several requests are pending here; reply with the ID: "+strings.Join(ids, ", "))
+		return true
+	}
+
+	by := msg.Username
+	if by == "" {
+		by = msg.UserID
+	}
+	if err := b.Decide(target.ID, approved, by, amount); err != nil {
+		b.mm.Notify(msg.ThreadID, err.Error())
+	}
+	return true
+}
+
+// Decide records the director's decision and dispatches it to the handler
+// registered for the approval's kind
+func (b *Broker) Decide(id string, approved bool, by string, amount int64) error {
+	var decided *state.Approval
+	ok := b.state.UpdateApproval(id, func(a *state.Approval) {
+		if a.Status != state.ApprovalPending {
+			return
+		}
+		a.Status = state.ApprovalDenied
+		if approved {
+			a.Status = state.ApprovalApproved
+		}
+		a.DecidedBy = by
+		a.DecidedAt = time.Now()
+		if amount > 0 {
+			a.Amount = amount
+		}
+		cp := *a
+		decided = &cp
+	})
+	if !ok {
+		return fmt.Errorf("unknown request %s", id)
+	}
+	if decided == nil {
+		return fmt.Errorf("request %s was already decided", id)
+	}
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist approval", "error", err)
+	}
+	b.logger.Info("Approval decided", "id", id, "kind", decided.Kind, "status", decided.Status, "by", by)
+	b.markDecided(decided)
+
+	b.mu.RLock()
+	h := b.handlers[decided.Kind]
+	b.mu.RUnlock()
+	if h == nil {
+		b.logger.Warn("No handler for approval kind", "kind", decided.Kind)
+		return nil
+	}
+	h(decided)
+	return nil
+}
+
+// parseReply reads a decision from a thread reply. ok is false when the
+// reply is not one.
+func parseReply(text string) (approved bool, id string, amount int64, ok bool) {
+	m := replyPattern.FindStringSubmatch(text)
+	if m == nil {
+		return false, "", 0, false
+	}
+	verb := strings.ToLower(m[1])
+	if (verb == "yes" || verb == "no") && m[2] == "" {
+		return false, "", 0, false
+	}
+	approved = verb == "approve" || verb == "approved" || verb == "yes"
+	return approved, strings.ToLower(m[2]), parseAmount(m[3]), true
+}
+
+// parseAmount reads "+50000", "+50k" or "+2m"
+func parseAmount(s string) int64 {
+	s = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "+")))
+	if s == "" {
+		return 0
+	}
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		mult, s = 1000000, strings.TrimSuffix(s, "m")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * mult
+}
+
+func newID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package approval
+
+import "testing"
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		text     string
+		ok       bool
+		approved bool
+		id       string
+		amount   int64
+	}{
+		{text: "approve", ok: true, approved: true},
+		{text: "  Approved.", ok: true, approved: true},
+		{text: "deny", ok: true},
+		{text: "DENIED!", ok: true},
+		{text: "approve a1b2c3", ok: true, approved: true, id: "a1b2c3"},
+		{text: "deny A1B2C3", ok: true, id: "a1b2c3"},
+		{text: "approve +50000", ok: true, approved: true, amount: 50000},
+		{text: "approve a1b2c3 +50k", ok: true, approved: true, id: "a1b2c3", amount: 50000},
+		{text: "approve + 2m", ok: true, approved: true, amount: 2000000},
+		{text: "yes a1b2c3", ok: true, approved: true, id: "a1b2c3"},
+		{text: "no a1b2c3", ok: true, id: "a1b2c3"},
+
+		// A bare yes or no is conversation
+		{text: "yes"},
+		{text: "No."},
+		{text: "yes +50k"},
+
+		{text: ""},
+		{text: "approve this please"},
+		{text: "approved by whom?"},
+		{text: "approve a1b2c"},
+		{text: "approve a1b2c3d4"},
+		{text: "approvethis"},
+		{text: "nope"},
+		{text: "I approve"},
+	}
+	for _, tt := range tests {
+		approved, id, amount, ok := parseReply(tt.text)
+		if ok != tt.ok || approved != tt.approved || id != tt.id || amount != tt.amount {
+			t.Errorf("parseReply(%q) = %v, %q, %d, %v; want %v, %q, %d, %v",
+				tt.text, approved, id, amount, ok, tt.approved, tt.id, tt.amount, tt.ok)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"+50000", 50000},
+		{"+ 50000", 50000},
+		{"+50k", 50000},
+		{"+50K", 50000},
+		{"+2m", 2000000},
+		{"+k", 0},
+		{"+abc", 0},
+	}
+	for _, tt := range tests {
+		if got := parseAmount(tt.in); got != tt.want {
+			t.Errorf("parseAmount(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
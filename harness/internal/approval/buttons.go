@@ -0,0 +1,185 @@
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// ActionPath is where the web server receives approval button clicks
+const ActionPath = "/api/mattermost/actions"
+
+// Attachment colors
+const (
+	colorPending  = "#e0af68"
+	colorApproved = "#9ece6a"
+	colorDenied   = "#f7768e"
+)
+
+// newActionToken returns the secret an approval's buttons carry
+func newActionToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+// postButtons queues an approval prompt with Approve and Deny buttons and
+// remembers the post once delivered so it can be updated when decided. If
+// the buttons cannot be posted the prompt is asked in text instead.
+func (b *Broker) postButtons(a *state.Approval, hint string) {
+	url := strings.TrimRight(b.state.Config().Mattermost.CallbackURL, "/") + ActionPath
+	button := func(id, name, style string) mattermost.Action {
+		return mattermost.Action{
+			ID:    id,
+			Name:  name,
+			Style: style,
+			Integration: mattermost.Integration{
+				URL: url,
+				Context: map[string]interface{}{
+					"approval": a.ID,
+					"decision": id,
+					"token":    a.ActionToken,
+				},
+			},
+		}
+	}
+	d := b.mm.Send(mattermost.Post{
+		RootID:  a.ThreadID,
+		Message: a.Prompt,
+		Attachments: []mattermost.Attachment{{
+			Fallback: hint,
+			Color:    colorPending,
+			Text:     fmt.Sprintf("Request `%s` · %s", a.ID, hint),
+			Actions: []mattermost.Action{
+				button("approve", "Approve", "primary"),
+				button("deny", "Deny", "danger"),
+			},
+		}},
+	})
+	go func() {
+		if err := d.Wait(context.Background()); err != nil {
+			b.logger.Warn("Failed to post approval buttons; asking in text", "approval", a.ID, "error", err)
+			b.mm.Notify(a.ThreadID, fmt.Sprintf("%s\n\n_%s._", a.Prompt, hint))
+			return
+		}
+		b.state.UpdateApproval(a.ID, func(sa *state.Approval) {
+			sa.PostID = d.PostID()
+		})
+		if err := b.state.Save(); err != nil {
+			b.logger.Warn("Failed to persist approval", "error", err)
+		}
+		// Decided in text before the buttons arrived
+		if cur := b.state.GetApproval(a.ID); cur != nil && cur.Status != state.ApprovalPending {
+			b.markDecided(cur)
+		}
+	}()
+}
+
+// decidedAttachments replaces the buttons of a decided approval's post
+func decidedAttachments(a *state.Approval) []mattermost.Attachment {
+	color := colorDenied
+	if a.Status == state.ApprovalApproved {
+		color = colorApproved
+	}
+	text := fmt.Sprintf("Request `%s` **%s** by %s", a.ID, a.Status, a.DecidedBy)
+	if a.Amount > 0 && a.Status == state.ApprovalApproved {
+		text += fmt.Sprintf(" (%d tokens)", a.Amount)
+	}
+	return []mattermost.Attachment{{Fallback: text, Color: color, Text: text}}
+}
+
+// markDecided updates the post that asked for an approval to show the
+// decision, however it was made
+func (b *Broker) markDecided(a *state.Approval) {
+	if b.mm == nil || a.PostID == "" {
+		return
+	}
+	if err := b.mm.UpdatePost(a.PostID, a.Prompt, decidedAttachments(a)); err != nil {
+		b.logger.Warn("Failed to update approval post", "approval", a.ID, "error", err)
+	}
+}
+
+// HandleAction decides an approval from a Mattermost button click. The
+// callback endpoint is unauthenticated, so the click must carry the
+// approval's token, which only the Mattermost server and the harness know
+// (Mattermost keeps action context from clients), and name the post that
+// asked. Only then is the user ID trusted as Mattermost's, and the clicker
+// must be an approver by the username it resolves to; the body's
+// user_name is never used.
+func (b *Broker) HandleAction(req mattermost.ActionRequest) mattermost.ActionResponse {
+	id, _ := req.Context["approval"].(string)
+	decision, _ := req.Context["decision"].(string)
+	token, _ := req.Context["token"].(string)
+
+	a := b.state.GetApproval(id)
+	if b.mm == nil || a == nil || a.ActionToken == "" || a.PostID == "" || req.PostID != a.PostID ||
+		(decision != "approve" && decision != "deny") || subtle.ConstantTimeCompare([]byte(token), []byte(a.ActionToken)) != 1 {
+		b.logger.Warn("Rejected approval button with a bad token or post", "approval", id, "post", req.PostID, "user_id", req.UserID)
+		return mattermost.ActionResponse{EphemeralText: "This button is no longer valid."}
+	}
+
+	u, err := b.mm.Users().User(req.UserID)
+	if err != nil {
+		b.logger.Warn("Could not resolve approval clicker; treating as unauthorized", "approval", id, "user_id", req.UserID, "error", err)
+		return mattermost.ActionResponse{EphemeralText: "Sorry, I could not verify who you are. Try again, or reply in the thread."}
+	}
+	username := u.Username
+	msg := mattermost.Message{UserID: req.UserID, Username: username, ChannelID: req.ChannelID, ThreadID: a.ThreadID}
+	if !b.mm.Authorized(msg, state.RoleApprover) {
+		b.logger.Warn("Unauthorized approval click", "approval", id, "user", username, "user_id", req.UserID)
+		return mattermost.ActionResponse{EphemeralText: fmt.Sprintf("Sorry @%s, you are not authorized to decide approval requests.", username)}
+	}
+
+	if err := b.Decide(id, decision == "approve", username, 0); err != nil {
+		return mattermost.ActionResponse{EphemeralText: err.Error()}
+	}
+	decided := b.state.GetApproval(id)
+	return mattermost.ActionResponse{Update: &mattermost.PostUpdate{
+		Message: decided.Prompt,
+		Props:   map[string]interface{}{"attachments": decidedAttachments(decided)},
+	}}
+}
+
+// Reactions that decide an approval prompt
+var (
+	approveEmoji = map[string]bool{"+1": true, "thumbsup": true}
+	denyEmoji    = map[string]bool{"-1": true, "thumbsdown": true}
+)
+
+// HandleReaction decides an approval from a thumbs-up or thumbs-down on
+// its prompt. Returns true when the reaction was consumed.
+func (b *Broker) HandleReaction(r mattermost.Reaction) bool {
+	approved := approveEmoji[r.Emoji]
+	if !approved && !denyEmoji[r.Emoji] {
+		return false
+	}
+	var target *state.Approval
+	for _, a := range b.state.PendingApprovals(r.ThreadID) {
+		if a.PostID == r.PostID {
+			target = a
+		}
+	}
+	if target == nil {
+		return false
+	}
+
+	msg := r.Message()
+	if !b.mm.Authorized(msg, state.RoleApprover) {
+		b.mm.Refuse(msg, "decide approval requests")
+		return true
+	}
+	by := r.Username
+	if by == "" {
+		by = r.UserID
+	}
+	if err := b.Decide(target.ID, approved, by, 0); err != nil {
+		b.mm.Notify(r.ThreadID, err.Error())
+	}
+	return true
+}
@@ -52,10 +52,10 @@ func (m *Monitor) checkAllWorkers() {
 		}
 		if clients > 0 && w.Status == state.WorkerRunning {
 			m.logger.Info("Director hijack detected", "worker", w.ID, "clients", clients)
-			w.Status = state.WorkerHijacked
+			m.setStatus(w.ID, state.WorkerRunning, state.WorkerHijacked)
 		} else if clients == 0 && w.Status == state.WorkerHijacked {
 			m.logger.Info("Director detached from worker", "worker", w.ID)
-			w.Status = state.WorkerRunning
+			m.setStatus(w.ID, state.WorkerHijacked, state.WorkerRunning)
 		}
 	}
 }
@@ -88,3 +88,13 @@ func CountTmuxClients(containerID string) (int, error) {
 	_ = count
 	return getTmuxClientCount(containerID)
 }
+
+// setStatus moves a worker from one status to another, unless something
+// else changed it since it was read
+func (m *Monitor) setStatus(id string, from, to state.WorkerStatus) {
+	m.state.UpdateWorker(id, func(w *state.Worker) {
+		if w.Status == from {
+			w.Status = to
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package isolation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// baselineRef marked the snapshot in copies made before the baseline was
+// kept in harness state; it is only read to adopt such copies
+const baselineRef = "refs/tags/workspace-baseline"
+
+// ScratchDir returns the directory that holds copies and patches for tasks.
+// WORKSPACE_SCRATCH_DIR overrides the default under the host home.
+func ScratchDir() string {
+	if dir := os.Getenv("WORKSPACE_SCRATCH_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(state.HostHome(), ".cache", "workspace-agent", "scratch")
+}
+
+// CopyPath returns where a task's copy of the project lives
+func CopyPath(taskID string) string {
+	return filepath.Join(ScratchDir(), taskID)
+}
+
+// PatchPath returns where a task's changeset is written. It sits beside the
+// copy rather than inside it so the worker can't see or edit it.
+func PatchPath(taskID string) string {
+	return filepath.Join(ScratchDir(), taskID+".patch")
+}
+
+// CreateCopy snapshots src into dst, honoring .gitignore. The copy gets its
+// own git repository with a baseline commit so changes can be diffed back
+// regardless of whether src is itself a git repository. Returns the
+// baseline commit, for the caller to keep where the worker can't move it.
+// A reused copy returns "", as its baseline is already kept, unless it
+// predates that and still carries the baseline tag.
+func CreateCopy(src, dst string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dst, ".git")); err == nil {
+		base, _ := git(dst, "rev-parse", "--verify", "--quiet", baselineRef+"^{commit}")
+		return base, nil
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return "", err
+	}
+	if _, err := git(dst, "init", "--quiet"); err != nil {
+		return "", err
+	}
+	// Stage src into the copy's index: add -A with src as the work tree
+	// applies src's .gitignore files and skips its .git directory.
+	gitDir := filepath.Join(dst, ".git")
+	if _, err := git(src, "--git-dir="+gitDir, "--work-tree="+src, "add", "-A", "."); err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	if _, err := commit(dst, "--allow-empty", "-m", "workspace-agent baseline of "+src); err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	base, err := git(dst, "rev-parse", "HEAD")
+	if err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	if _, err := git(dst, "checkout", "--quiet", "--force"); err != nil {
+		os.RemoveAll(dst)
+		return "", err
+	}
+	return base, nil
+}
+
+// WriteCopyPatch writes everything changed in the copy since base,
+// including commits the worker made and untracked files, to patchPath.
+// Returns a diffstat summary, empty when nothing changed.
+func WriteCopyPatch(dst, base, patchPath string) (string, error) {
+	if base == "" {
+		return "", fmt.Errorf("no baseline recorded for %s", dst)
+	}
+	patch, stat, err := Diff(dst, base)
+	if err != nil {
+		return "", err
+	}
+	if patch == "" {
+		os.Remove(patchPath)
+		return "", nil
+	}
+	if err := os.WriteFile(patchPath, []byte(patch), 0644); err != nil {
+		return "", err
+	}
+	return stat, nil
+}
+
+// ApplyPatch applies a copy patch to the original project. The whole patch
+// is checked first so a conflict leaves the original untouched.
+func ApplyPatch(repo, patchPath string) error {
+	if _, err := git(repo, "apply", "--check", "--binary", patchPath); err != nil {
+		return fmt.Errorf("patch does not apply cleanly: %w", err)
+	}
+	_, err := git(repo, "apply", "--binary", patchPath)
+	return err
+}
+
+// RemoveCopy deletes a task's copy and patch
+func RemoveCopy(taskID string) error {
+	if err := os.RemoveAll(CopyPath(taskID)); err != nil {
+		return err
+	}
+	if err := os.Remove(PatchPath(taskID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// commit records a commit in the copy under the harness identity, so it
+// works without a user git config
+func commit(dir string, args ...string) (string, error) {
+	return git(dir, append([]string{
+		"-c", "user.name=workspace-agent",
+		"-c", "user.email=harness@workspace-agent.local",
+		"-c", "commit.gpgsign=false",
+		"commit", "--quiet", "--no-verify",
+	}, args...)...)
+}
@@ -0,0 +1,106 @@
+package isolation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Isolation modes accepted in workspace.yaml
+const (
+	ModeWorktree = "worktree"
+	ModeBind     = "bind"
+	ModeCopy     = "copy"
+)
+
+// Workspace is the host-side checkout a worker mounts as its project
+type Workspace struct {
+	Mode   string
+	Path   string // host path mounted at /workspace/project
+	Branch string
+	Base   string // commit the workspace started from, for diffing; empty for a reused copy
+	// Extra host paths the worker needs mounted at the same location,
+	// e.g. the common .git directory a worktree points back to
+	Extra []string
+}
+
+// Prepare provisions the workspace for a task according to the project's
+// isolation mode. Calling it again for the same task reuses the workspace.
+func Prepare(project *state.Project, taskID string) (*Workspace, error) {
+	repo := project.HostPath()
+	switch mode(project) {
+	case ModeWorktree:
+		wt, err := CreateWorktree(repo, taskID)
+		if err != nil {
+			return nil, err
+		}
+		return &Workspace{
+			Mode:   ModeWorktree,
+			Path:   wt.Path,
+			Branch: wt.Branch,
+			Base:   wt.Base,
+			Extra:  []string{wt.GitDir},
+		}, nil
+	case ModeBind:
+		return &Workspace{Mode: ModeBind, Path: repo}, nil
+	case ModeCopy:
+		dst := CopyPath(taskID)
+		base, err := CreateCopy(repo, dst)
+		if err != nil {
+			return nil, err
+		}
+		return &Workspace{Mode: ModeCopy, Path: dst, Base: base}, nil
+	default:
+		return nil, fmt.Errorf("isolation mode %q is not supported", project.Isolation)
+	}
+}
+
+// Release tears down a task's workspace. Bind mounts have nothing to clean up.
+func Release(project *state.Project, task *state.Task, force bool) error {
+	switch task.Isolation {
+	case ModeWorktree:
+		return RemoveWorktree(project.HostPath(), task.WorktreePath, task.Branch, force)
+	case ModeCopy:
+		if !force && task.PatchStatus == state.PatchPending {
+			return fmt.Errorf("task %s has an unreviewed patch; apply or discard it first", task.ID)
+		}
+		return RemoveCopy(task.ID)
+	default:
+		return nil
+	}
+}
+
+func mode(project *state.Project) string {
+	if project.Isolation == "" {
+		return ModeBind
+	}
+	return project.Isolation
+}
+
+// Diff returns everything changed in a workspace since base, whether
+// committed, staged or untracked, and a diffstat summary, empty when nothing
+// changed. A scratch index is used so the worker's own index is untouched.
+func Diff(path, base string) (string, string, error) {
+	dir, err := os.MkdirTemp("", "workspace-index-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(dir)
+	index := filepath.Join(dir, "index")
+
+	if _, err := gitIndex(path, index, "read-tree", "HEAD"); err != nil {
+		return "", "", err
+	}
+	if _, err := gitIndex(path, index, "add", "-A", "."); err != nil {
+		return "", "", err
+	}
+	patch, err := gitIndex(path, index, "diff", "--cached", "--binary", base)
+	if err != nil || patch == "" {
+		return "", "", err
+	}
+	stat, err := gitIndex(path, index, "diff", "--cached", "--stat", base)
+	return patch, strings.TrimSpace(stat), err
+}
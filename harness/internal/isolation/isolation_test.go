@@ -0,0 +1,180 @@
+package isolation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// newRepo creates a git repository with one commit holding files
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	repo := t.TempDir()
+	if _, err := git(repo, "init", "--quiet"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, files)
+	if _, err := git(repo, "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit(repo, "-m", "initial"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrepareWorktree(t *testing.T) {
+	repo := newRepo(t, map[string]string{"main.go": "package main\n"})
+	head, _ := git(repo, "rev-parse", "HEAD")
+	project := &state.Project{Alias: "api", Path: repo, Isolation: ModeWorktree}
+
+	ws, err := Prepare(project, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Path != filepath.Join(repo, WorktreeDir, "task1") || ws.Branch != BranchPrefix+"task1" {
+		t.Errorf("worktree at %s on %s", ws.Path, ws.Branch)
+	}
+	if ws.Base != head {
+		t.Errorf("Base = %s, want %s", ws.Base, head)
+	}
+
+	// The worker commits one change and leaves another uncommitted
+	writeFiles(t, ws.Path, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	if _, err := git(ws.Path, "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit(ws.Path, "-m", "add main"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, ws.Path, map[string]string{"notes.txt": "untracked\n"})
+
+	again, err := Prepare(project, "task1")
+	if err != nil {
+		t.Fatalf("reuse: %v", err)
+	}
+	if again.Path != ws.Path || again.Base != head {
+		t.Errorf("reuse: worktree at %s from %s, want %s from %s", again.Path, again.Base, ws.Path, head)
+	}
+
+	statusBefore, _ := git(ws.Path, "status", "--porcelain")
+	patch, stat, err := Diff(ws.Path, ws.Base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"func main() {}", "notes.txt"} {
+		if !strings.Contains(patch, want) {
+			t.Errorf("patch is missing %q:\n%s", want, patch)
+		}
+	}
+	if !strings.Contains(stat, "2 files changed") {
+		t.Errorf("stat = %q", stat)
+	}
+	if statusAfter, _ := git(ws.Path, "status", "--porcelain"); statusAfter != statusBefore {
+		t.Errorf("Diff changed the worker's index: %q, was %q", statusAfter, statusBefore)
+	}
+}
+
+func TestPrepareCopy(t *testing.T) {
+	t.Setenv("WORKSPACE_SCRATCH_DIR", t.TempDir())
+	repo := newRepo(t, map[string]string{
+		"main.go":    "package main\n",
+		".gitignore": "build/\n",
+	})
+	writeFiles(t, repo, map[string]string{"build/out": "ignored\n", "wip.go": "package main\n"})
+	project := &state.Project{Alias: "api", Path: repo, Isolation: ModeCopy}
+
+	ws, err := Prepare(project, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Path != CopyPath("task1") || ws.Base == "" {
+		t.Fatalf("copy at %s from %q", ws.Path, ws.Base)
+	}
+	if _, err := os.Stat(filepath.Join(ws.Path, "wip.go")); err != nil {
+		t.Error("uncommitted file was not copied")
+	}
+	if _, err := os.Stat(filepath.Join(ws.Path, "build", "out")); err == nil {
+		t.Error("ignored file was copied")
+	}
+
+	patch, _, err := Diff(ws.Path, ws.Base)
+	if err != nil || patch != "" {
+		t.Errorf("fresh copy diff = %q, %v", patch, err)
+	}
+
+	// The worker commits one change and leaves another uncommitted
+	writeFiles(t, ws.Path, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	if _, err := git(ws.Path, "add", "-A"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit(ws.Path, "-m", "add main"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, ws.Path, map[string]string{"notes.txt": "untracked\n"})
+
+	again, err := Prepare(project, "task1")
+	if err != nil {
+		t.Fatalf("reuse: %v", err)
+	}
+	if again.Path != ws.Path || again.Base != "" {
+		t.Errorf("reuse: copy at %s from %q, want %s from the kept baseline", again.Path, again.Base, ws.Path)
+	}
+
+	stat, err := WriteCopyPatch(ws.Path, ws.Base, PatchPath("task1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stat, "2 files changed") {
+		t.Errorf("stat = %q", stat)
+	}
+	if err := ApplyPatch(repo, PatchPath("task1")); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(repo, "main.go"))
+	if !strings.Contains(string(data), "func main() {}") {
+		t.Errorf("patch not applied to the original: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "notes.txt")); err != nil {
+		t.Error("untracked file not applied to the original")
+	}
+}
+
+func TestCreateCopyAdoptsLegacyBaseline(t *testing.T) {
+	repo := newRepo(t, map[string]string{"main.go": "package main\n"})
+	dst := filepath.Join(t.TempDir(), "copy")
+	base, err := CreateCopy(repo, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git(dst, "tag", "workspace-baseline", base); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit(dst, "--allow-empty", "-m", "worker commit"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CreateCopy(repo, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != base {
+		t.Errorf("legacy copy baseline = %q, want %q", got, base)
+	}
+}
@@ -0,0 +1,175 @@
+package isolation
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WorktreeDir is the directory under a project root that holds task worktrees
+const WorktreeDir = ".worktrees"
+
+// BranchPrefix namespaces the branches created for tasks
+const BranchPrefix = "agent/"
+
+// Worktree is a git worktree checked out for a single task
+type Worktree struct {
+	Repo   string // main checkout the worktree belongs to
+	Path   string // worktree checkout on the host
+	Branch string
+	GitDir string // common .git directory, mounted into workers at the same path
+	Base   string // commit the branch forked from the main checkout
+}
+
+// CreateWorktree creates a branch and git worktree for a task under repo.
+// An existing worktree for the task is reused so respawned workers pick up
+// where the previous one left off.
+func CreateWorktree(repo, taskID string) (*Worktree, error) {
+	gitDir, err := commonGitDir(repo)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", repo, err)
+	}
+	wt := &Worktree{
+		Repo:   repo,
+		Path:   filepath.Join(repo, WorktreeDir, taskID),
+		Branch: BranchPrefix + taskID,
+		GitDir: gitDir,
+	}
+
+	if _, err := os.Stat(filepath.Join(wt.Path, ".git")); err != nil {
+		if err := excludeWorktreeDir(repo); err != nil {
+			return nil, fmt.Errorf("exclude %s: %w", WorktreeDir, err)
+		}
+		args := []string{"worktree", "add"}
+		if branchExists(repo, wt.Branch) {
+			args = append(args, wt.Path, wt.Branch)
+		} else {
+			args = append(args, "-b", wt.Branch, wt.Path)
+		}
+		if _, err := git(repo, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	base, err := git(repo, "merge-base", "HEAD", wt.Branch)
+	if err != nil {
+		return nil, err
+	}
+	wt.Base = base
+	return wt, nil
+}
+
+// RemoveWorktree removes a task worktree. It refuses to discard uncommitted
+// changes unless force is set, and only deletes the branch once it has been
+// merged so unmerged work is never lost.
+func RemoveWorktree(repo, path, branch string, force bool) error {
+	if _, err := os.Stat(path); err == nil {
+		if !force {
+			dirty, err := git(path, "status", "--porcelain")
+			if err != nil {
+				return err
+			}
+			if dirty != "" {
+				return fmt.Errorf("worktree %s has uncommitted changes", path)
+			}
+		}
+		args := []string{"worktree", "remove", path}
+		if force {
+			args = []string{"worktree", "remove", "--force", path}
+		}
+		if _, err := git(repo, args...); err != nil {
+			return err
+		}
+	}
+	if _, err := git(repo, "worktree", "prune"); err != nil {
+		return err
+	}
+	if branch != "" && branchExists(repo, branch) {
+		// -d (not -D) keeps branches that still carry unmerged commits
+		if _, err := git(repo, "branch", "-d", branch); err != nil {
+			return fmt.Errorf("worktree removed but branch %s kept: %w", branch, err)
+		}
+	}
+	return nil
+}
+
+func commonGitDir(repo string) (string, error) {
+	dir, err := git(repo, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repo, dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+func branchExists(repo, branch string) bool {
+	_, err := git(repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch)
+	return err == nil
+}
+
+// excludeWorktreeDir keeps the worktree directory out of the main checkout's
+// git status without touching the tracked .gitignore
+func excludeWorktreeDir(repo string) error {
+	path, err := git(repo, "rev-parse", "--git-path", "info/exclude")
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(repo, path)
+	}
+	entry := "/" + WorktreeDir + "/"
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == entry {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		entry = "\n" + entry
+	}
+	_, err = f.WriteString(entry + "\n")
+	return err
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	out, err := gitRaw(dir, args...)
+	return strings.TrimSpace(out), err
+}
+
+// gitRaw runs a git command in dir. safe.directory is relaxed because the
+// harness runs as root against repos owned by the host user.
+func gitRaw(dir string, args ...string) (string, error) {
+	return gitIndex(dir, "", args...)
+}
+
+// gitIndex runs a git command in dir against another index file, or the
+// repository's own when index is empty
+func gitIndex(dir, index string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-c", "safe.directory=*", "-C", dir}, args...)...)
+	if index != "" {
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+index)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
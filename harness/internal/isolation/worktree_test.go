@@ -0,0 +1,123 @@
+package isolation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateWorktree(t *testing.T) {
+	repo := newRepo(t, map[string]string{"README.md": "# api\n"})
+	head, _ := git(repo, "rev-parse", "HEAD")
+
+	wt, err := CreateWorktree(repo, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wt.Repo != repo || wt.Path != filepath.Join(repo, WorktreeDir, "task1") || wt.Branch != "agent/task1" || wt.Base != head {
+		t.Errorf("CreateWorktree = %+v", wt)
+	}
+	if wt.GitDir != filepath.Join(repo, ".git") {
+		t.Errorf("GitDir = %s", wt.GitDir)
+	}
+	if branch, _ := git(wt.Path, "rev-parse", "--abbrev-ref", "HEAD"); branch != wt.Branch {
+		t.Errorf("worktree is on %s, want %s", branch, wt.Branch)
+	}
+	// The worktree directory stays out of the main checkout's status
+	if status, _ := git(repo, "status", "--porcelain"); status != "" {
+		t.Errorf("main checkout status = %q", status)
+	}
+
+	// Work left behind survives a reuse, and the exclude entry is not repeated
+	writeFiles(t, wt.Path, map[string]string{"wip.txt": "half done\n"})
+	again, err := CreateWorktree(repo, "task1")
+	if err != nil {
+		t.Fatalf("reuse: %v", err)
+	}
+	if again.Path != wt.Path || again.Base != head {
+		t.Errorf("reuse = %+v", again)
+	}
+	if _, err := os.Stat(filepath.Join(wt.Path, "wip.txt")); err != nil {
+		t.Errorf("reuse lost uncommitted work: %v", err)
+	}
+	exclude, _ := os.ReadFile(filepath.Join(repo, ".git", "info", "exclude"))
+	if n := strings.Count(string(exclude), "/"+WorktreeDir+"/"); n != 1 {
+		t.Errorf("exclude lists the worktree directory %d times:\n%s", n, exclude)
+	}
+}
+
+func TestCreateWorktreeExistingBranch(t *testing.T) {
+	repo := newRepo(t, map[string]string{"README.md": "# api\n"})
+	head, _ := git(repo, "rev-parse", "HEAD")
+	wt, err := CreateWorktree(repo, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, wt.Path, map[string]string{"fix.go": "package api\n"})
+	git(wt.Path, "add", "-A")
+	if _, err := commit(wt.Path, "-m", "fix"); err != nil {
+		t.Fatal(err)
+	}
+	tip, _ := git(wt.Path, "rev-parse", "HEAD")
+
+	// The checkout goes away but the branch with the worker's commit stays
+	if _, err := git(repo, "worktree", "remove", "--force", wt.Path); err != nil {
+		t.Fatal(err)
+	}
+	again, err := CreateWorktree(repo, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := git(again.Path, "rev-parse", "HEAD"); got != tip {
+		t.Errorf("recreated worktree at %s, want the branch tip %s", got, tip)
+	}
+	if again.Base != head {
+		t.Errorf("Base = %s, want %s", again.Base, head)
+	}
+}
+
+func TestCreateWorktreeNotARepo(t *testing.T) {
+	if _, err := CreateWorktree(t.TempDir(), "task1"); err == nil {
+		t.Error("created a worktree outside a git repository")
+	}
+}
+
+func TestRemoveWorktree(t *testing.T) {
+	repo := newRepo(t, map[string]string{"README.md": "# api\n"})
+	wt, err := CreateWorktree(repo, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, wt.Path, map[string]string{"wip.txt": "half done\n"})
+	if err := RemoveWorktree(repo, wt.Path, wt.Branch, false); err == nil {
+		t.Fatal("removed a worktree with uncommitted changes")
+	}
+
+	git(wt.Path, "add", "-A")
+	if _, err := commit(wt.Path, "-m", "wip"); err != nil {
+		t.Fatal(err)
+	}
+	// Unmerged commits keep the branch
+	if err := RemoveWorktree(repo, wt.Path, wt.Branch, false); err == nil {
+		t.Error("deleted a branch with unmerged commits")
+	}
+	if _, err := os.Stat(wt.Path); !os.IsNotExist(err) {
+		t.Errorf("worktree still at %s", wt.Path)
+	}
+	if !branchExists(repo, wt.Branch) {
+		t.Error("unmerged branch deleted")
+	}
+
+	// A merged branch goes with its worktree
+	merged, err := CreateWorktree(repo, "task2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveWorktree(repo, merged.Path, merged.Branch, false); err != nil {
+		t.Fatal(err)
+	}
+	if branchExists(repo, merged.Branch) {
+		t.Error("merged branch kept")
+	}
+}
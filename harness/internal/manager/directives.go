@@ -0,0 +1,228 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/privilege"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// ProtocolVersion is the directive protocol version this harness speaks.
+// protocol.md documents it; bump both together when the vocabulary changes
+// incompatibly.
+const ProtocolVersion = 1
+
+// Directive ops
+const (
+	OpSpawnWorker      = "spawn-worker"
+	OpKillWorker       = "kill-worker"
+	OpMessageWorker    = "message-worker"
+	OpMarkTaskDone     = "mark-task-done"
+	OpRequestPrivilege = "request-privilege"
+	OpStatus           = "status"
+)
+
+// directivePattern matches "[DIRECTIVE v1] {json}" on a line of its own
+var directivePattern = regexp.MustCompile(`^\[DIRECTIVE v(\d+)\]\s*(\{.*\})\s*$`)
+
+// Directive is one instruction from the manager agent to the harness
+type Directive struct {
+	ID string `json:"id"` // chosen by the manager, echoed in the reply
+	Op string `json:"op"`
+
+	ThreadID   string `json:"thread,omitempty"`
+	Project    string `json:"project,omitempty"`
+	WorkerType string `json:"type,omitempty"`
+	Prompt     string `json:"prompt,omitempty"`
+	WorkerID   string `json:"worker,omitempty"`
+	TaskID     string `json:"task,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Privilege  string `json:"privilege,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Reply answers a directive on the manager's stdin
+type Reply struct {
+	ID     string      `json:"id"`
+	Op     string      `json:"op"`
+	OK     bool        `json:"ok"`
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// directiveWorker is a worker as reported in directive replies
+type directiveWorker struct {
+	ID      string `json:"id"`
+	Project string `json:"project"`
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	TaskID  string `json:"task,omitempty"`
+	Thread  string `json:"thread,omitempty"`
+}
+
+// directiveTask is a task as reported in directive replies
+type directiveTask struct {
+	ID      string `json:"id"`
+	Project string `json:"project"`
+	Status  string `json:"status"`
+	Thread  string `json:"thread,omitempty"`
+}
+
+// ParseDirective extracts a directive from a line of manager text. ok is
+// false when the line is not a directive; a malformed directive returns
+// ok with an error so it can be answered.
+func ParseDirective(line string) (d Directive, version int, ok bool, err error) {
+	m := directivePattern.FindStringSubmatch(line)
+	if m == nil {
+		return Directive{}, 0, false, nil
+	}
+	version, _ = strconv.Atoi(m[1])
+	if err := json.Unmarshal([]byte(m[2]), &d); err != nil {
+		return d, version, true, fmt.Errorf("malformed directive: %w", err)
+	}
+	return d, version, true, nil
+}
+
+// handleDirective executes a directive and replies on the manager's stdin.
+// Directives may block on docker, so they run off the output loop.
+func (m *Manager) handleDirective(line string) {
+	d, version, _, err := ParseDirective(line)
+	if err == nil && version != ProtocolVersion {
+		err = fmt.Errorf("unsupported protocol version %d (this harness speaks v%d)", version, ProtocolVersion)
+	}
+	if err != nil {
+		m.logger.Warn("Rejected manager directive", "line", line, "error", err)
+		m.reply(Reply{ID: d.ID, Op: d.Op, Error: err.Error()})
+		return
+	}
+	m.logger.Info("Manager directive", "id", d.ID, "op", d.Op)
+	go func() {
+		result, err := m.execute(d)
+		r := Reply{ID: d.ID, Op: d.Op, OK: err == nil, Result: result}
+		if err != nil {
+			m.logger.Warn("Manager directive failed", "id", d.ID, "op", d.Op, "error", err)
+			r.Error = err.Error()
+		}
+		m.reply(r)
+	}()
+}
+
+func (m *Manager) execute(d Directive) (interface{}, error) {
+	switch d.Op {
+	case OpSpawnWorker:
+		if d.Project == "" || d.WorkerType == "" || d.Prompt == "" {
+			return nil, fmt.Errorf("spawn-worker needs project, type and prompt")
+		}
+		w, err := m.spawn(spawner.Request{
+			ThreadID:   d.ThreadID,
+			Project:    d.Project,
+			WorkerType: d.WorkerType,
+			Prompt:     d.Prompt,
+			TaskID:     d.TaskID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return workerResult(w), nil
+
+	case OpKillWorker:
+		if m.spawner == nil {
+			return nil, fmt.Errorf("no spawner configured")
+		}
+		if err := m.spawner.Kill(d.WorkerID); err != nil {
+			return nil, err
+		}
+		return workerResult(m.state.GetWorker(d.WorkerID)), nil
+
+	case OpMessageWorker:
+		if m.spawner == nil {
+			return nil, fmt.Errorf("no spawner configured")
+		}
+		if d.Text == "" {
+			return nil, fmt.Errorf("message-worker needs text")
+		}
+		return nil, m.spawner.Send(d.WorkerID, d.Text)
+
+	case OpMarkTaskDone:
+		if m.spawner == nil {
+			return nil, fmt.Errorf("no spawner configured")
+		}
+		if err := m.spawner.CompleteTask(d.TaskID); err != nil {
+			return nil, err
+		}
+		return taskResult(m.state.GetTask(d.TaskID)), nil
+
+	case OpRequestPrivilege:
+		if m.privileges == nil {
+			return nil, fmt.Errorf("privilege requests are not available")
+		}
+		// The decision arrives later as a [Harness] message
+		return nil, m.privileges.Request(privilege.Request{
+			WorkerID:      d.WorkerID,
+			PrivilegeID:   d.Privilege,
+			Justification: d.Reason,
+		})
+
+	case OpStatus:
+		workers := []directiveWorker{}
+		for _, w := range m.state.ListWorkers() {
+			if w.IsActive() {
+				workers = append(workers, workerResult(w))
+			}
+		}
+		tasks := []directiveTask{}
+		for _, t := range m.state.ListTasks() {
+			if t.Status == state.TaskActive {
+				tasks = append(tasks, taskResult(t))
+			}
+		}
+		return map[string]interface{}{"workers": workers, "tasks": tasks}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown op %q", d.Op)
+	}
+}
+
+// reply answers a directive through the input queue, so replies stay
+// ordered with other input and survive a manager restart
+func (m *Manager) reply(r Reply) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		m.logger.Error("Failed to encode directive reply", "id", r.ID, "error", err)
+		return
+	}
+	m.Enqueue(Input{
+		Source: SourceHarness,
+		Text:   fmt.Sprintf("[DIRECTIVE_REPLY v%d] %s", ProtocolVersion, payload),
+	})
+}
+
+func workerResult(w *state.Worker) directiveWorker {
+	if w == nil {
+		return directiveWorker{}
+	}
+	return directiveWorker{
+		ID:      w.ID,
+		Project: w.Project,
+		Type:    w.WorkerType,
+		Status:  string(w.Status),
+		TaskID:  w.TaskID,
+		Thread:  w.ThreadID,
+	}
+}
+
+func taskResult(t *state.Task) directiveTask {
+	if t == nil {
+		return directiveTask{}
+	}
+	return directiveTask{
+		ID:      t.ID,
+		Project: t.Project,
+		Status:  string(t.Status),
+		Thread:  t.ThreadID,
+	}
+}
@@ -0,0 +1,54 @@
+package manager
+
+import "testing"
+
+func TestParseDirective(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		wantErr bool
+		version int
+		want    Directive
+	}{
+		{
+			line:    `[DIRECTIVE v1] {"id":"d1","op":"spawn-worker","project":"api","type":"claude","prompt":"fix it","thread":"t1"}`,
+			ok:      true,
+			version: 1,
+			want:    Directive{ID: "d1", Op: OpSpawnWorker, Project: "api", WorkerType: "claude", Prompt: "fix it", ThreadID: "t1"},
+		},
+		{
+			line:    `[DIRECTIVE v1]{"id":"d2","op":"status"}  `,
+			ok:      true,
+			version: 1,
+			want:    Directive{ID: "d2", Op: OpStatus},
+		},
+		{
+			line:    `[DIRECTIVE v2] {"id":"d3","op":"status"}`,
+			ok:      true,
+			version: 2,
+			want:    Directive{ID: "d3", Op: OpStatus},
+		},
+		{
+			line:    `[DIRECTIVE v1] {"id":"d4","op":}`,
+			ok:      true,
+			wantErr: true,
+			version: 1,
+		},
+		{line: ""},
+		{line: "I'll spawn a worker for that."},
+		{line: `Sure: [DIRECTIVE v1] {"id":"d5","op":"status"}`},
+		{line: `[DIRECTIVE] {"id":"d6","op":"status"}`},
+		{line: `[DIRECTIVE v1] not json`},
+	}
+	for _, tt := range tests {
+		d, version, ok, err := ParseDirective(tt.line)
+		if ok != tt.ok || (err != nil) != tt.wantErr || version != tt.version {
+			t.Errorf("ParseDirective(%q) = v%d, ok %v, err %v; want v%d, ok %v, err %v",
+				tt.line, version, ok, err, tt.version, tt.ok, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && d != tt.want {
+			t.Errorf("ParseDirective(%q) = %+v, want %+v", tt.line, d, tt.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+const (
+	// restartDelay is the first restart delay; it doubles per quick exit
+	restartDelay = 3 * time.Second
+	// stableAfter is how long a manager must stay up before its exit no
+	// longer counts towards a crash loop
+	stableAfter = 2 * time.Minute
+	// stderrTailLines of manager stderr are kept for the director
+	stderrTailLines = 20
+)
+
+// tailWriter keeps the last lines written to it
+type tailWriter struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.push(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *tailWriter) push(line string) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return
+	}
+	t.lines = append(t.lines, line)
+	if len(t.lines) > stderrTailLines {
+		t.lines = t.lines[len(t.lines)-stderrTailLines:]
+	}
+}
+
+// tail returns the kept lines, including an unterminated last line
+func (t *tailWriter) tail() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := append([]string(nil), t.lines...)
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+	}
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+	return lines
+}
+
+// backoff returns the delay before the next restart after the given number
+// of quick exits in a row
+func backoff(crashes int, ceiling time.Duration) time.Duration {
+	delay := restartDelay
+	for i := 1; i < crashes && delay < ceiling; i++ {
+		delay *= 2
+	}
+	if delay > ceiling {
+		delay = ceiling
+	}
+	return delay
+}
+
+// exited records a manager exit and returns how long to wait before
+// restarting. Quick exits in a row back off exponentially; enough of them
+// mark the manager degraded and alert the director.
+func (m *Manager) exited(started time.Time, err error, stderr []string) time.Duration {
+	cfg := m.state.Config().Supervision
+	h := m.state.ManagerHealth()
+	now := time.Now()
+
+	if now.Sub(started) >= stableAfter {
+		h.Crashes = 0
+	}
+	h.Crashes++
+	h.LastExit = now
+	h.LastError = ""
+	if err != nil {
+		h.LastError = err.Error()
+	}
+	h.StderrTail = stderr
+
+	delay := backoff(h.Crashes, time.Duration(cfg.ManagerBackoffMaxSec)*time.Second)
+	h.NextRestart = now.Add(delay)
+
+	alert := !h.Degraded && h.Crashes >= cfg.ManagerCrashLoopRestarts
+	if alert {
+		h.Degraded = true
+	}
+	m.state.SetManagerHealth(h)
+
+	if alert {
+		m.logger.Error("Manager is crash-looping", "crashes", h.Crashes, "error", h.LastError, "next_restart", delay)
+		m.announceTail(degradedMessage(h, delay), h.StderrTail)
+	}
+	return delay
+}
+
+// stable is called once a manager process has stayed up long enough to
+// clear a crash loop
+func (m *Manager) stable() {
+	h := m.state.ManagerHealth()
+	wasDegraded := h.Degraded
+	h.Degraded = false
+	h.Crashes = 0
+	h.NextRestart = time.Time{}
+	m.state.SetManagerHealth(h)
+	if wasDegraded {
+		m.logger.Info("Manager recovered")
+		m.announce(fmt.Sprintf("The manager agent has been up for %s and is no longer degraded.", stableAfter))
+	}
+}
+
+// announce posts a manager health notice to the Mattermost channel
+func (m *Manager) announce(message string) {
+	if m.mm == nil {
+		m.announceFailed(message, errors.New("Mattermost is not configured"))
+		return
+	}
+	go m.confirm(message, m.mm.PostMessage("", "", message))
+}
+
+// announceTail posts a health notice with the manager's stderr tail
+// attached as a crash log
+func (m *Manager) announceTail(message string, tail []string) {
+	if m.mm == nil || len(tail) == 0 {
+		m.announce(message)
+		return
+	}
+	go m.confirm(message, m.mm.PostFile("", "", message, "manager-stderr.log", []byte(strings.Join(tail, "\n")+"\n")))
+}
+
+// confirm waits for a health notice to be delivered. A notice that never
+// reaches the channel is logged and shown in the TUI and web UI instead.
+func (m *Manager) confirm(message string, d *mattermost.Delivery) {
+	err := d.Wait(m.ctx)
+	if m.ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		m.announceFailed(message, err)
+		return
+	}
+	h := m.state.ManagerHealth()
+	if h.AnnounceError != "" {
+		h.AnnounceError = ""
+		m.state.SetManagerHealth(h)
+	}
+}
+
+func (m *Manager) announceFailed(message string, err error) {
+	m.logger.Error("Manager health notice did not reach Mattermost", "notice", message, "error", err)
+	h := m.state.ManagerHealth()
+	h.AnnounceError = err.Error()
+	m.state.SetManagerHealth(h)
+}
+
+func degradedMessage(h state.ManagerHealth, delay time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":warning: **Manager degraded**: the manager agent exited %d times in a row within %s of starting. Retrying every %s.",
+		h.Crashes, stableAfter, delay)
+	if h.LastError != "" {
+		fmt.Fprintf(&b, "\nLast error: `%s`", h.LastError)
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+// Input sources
+const (
+	SourceMattermost = "mattermost"
+	SourceWeb        = "web"
+	SourceTUI        = "tui"
+	SourceHarness    = "harness" // supervisors, privilege service, spawner
+)
+
+// Kinds of Mattermost input besides new messages
+const (
+	KindCorrection = "correction" // an edited message
+	KindRetraction = "retraction" // a deleted message
+	KindReaction   = "reaction"   // an emoji reaction to a manager post
+)
+
+// Input is one message for the manager agent
+type Input struct {
+	Source   string
+	Kind     string // for Mattermost input; empty for a new message
+	ThreadID string
+	User     string
+	Text     string
+	AckID    string // Mattermost inbox entry to acknowledge once answered
+	QueuedAt time.Time
+}
+
+// text renders an input the way the manager's rules expect to read it
+func (in Input) text() string {
+	text := strings.TrimRight(in.Text, "\n")
+	switch in.Source {
+	case SourceMattermost:
+		label := "From"
+		switch in.Kind {
+		case KindCorrection:
+			label = "Correction from"
+		case KindRetraction:
+			label = "Retraction from"
+		case KindReaction:
+			label = "Reaction from"
+		}
+		return fmt.Sprintf("[%s MM thread %s, user %s]: %s", label, in.ThreadID, in.User, text)
+	case SourceHarness:
+		return text
+	default:
+		from := in.Source
+		if in.User != "" {
+			from += ", user " + in.User
+		}
+		if in.ThreadID != "" {
+			from += ", thread " + in.ThreadID
+		}
+		return fmt.Sprintf("[From %s]: %s", from, text)
+	}
+}
+
+// inputQueue serializes everything sent to the manager. It outlives any one
+// manager process: inputs queued while the manager is down wait for the
+// next one, and an input whose write fails or whose turn never finished is
+// retried first.
+type inputQueue struct {
+	mu     sync.Mutex
+	items  []Input
+	signal chan struct{}
+
+	// Inputs written to the current process whose turn has not finished,
+	// oldest first, and when the last turn finished
+	inflight   []sentInput
+	lastResult time.Time
+}
+
+type sentInput struct {
+	in Input
+	at time.Time
+}
+
+func newInputQueue() *inputQueue {
+	return &inputQueue{signal: make(chan struct{}, 1)}
+}
+
+func (q *inputQueue) push(in Input) {
+	q.mu.Lock()
+	q.items = append(q.items, in)
+	q.mu.Unlock()
+	q.wake()
+}
+
+// requeue puts back an input that could not be delivered, ahead of the rest
+func (q *inputQueue) requeue(in Input) {
+	q.mu.Lock()
+	q.items = append([]Input{in}, q.items...)
+	q.mu.Unlock()
+}
+
+func (q *inputQueue) pop() (Input, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return Input{}, false
+	}
+	in := q.items[0]
+	q.items = q.items[1:]
+	return in, true
+}
+
+func (q *inputQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *inputQueue) wake() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// deliver writes queued inputs to one manager process's stdin as
+// stream-json user turns, in order, until ctx ends or a write fails.
+// Written inputs stay in flight until answered.
+func (q *inputQueue) deliver(ctx context.Context, w io.Writer) error {
+	for {
+		for {
+			in, ok := q.pop()
+			if !ok {
+				break
+			}
+			if _, err := w.Write(stream.UserTurn(in.text())); err != nil {
+				q.requeue(in)
+				return err
+			}
+			q.mu.Lock()
+			q.inflight = append(q.inflight, sentInput{in: in, at: time.Now()})
+			q.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.signal:
+		}
+	}
+}
+
+// answered records a finished turn and returns the inputs it answered: the
+// oldest in flight, plus any written before the previous turn finished,
+// which the manager read together with it
+func (q *inputQueue) answered() []Input {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for n < len(q.inflight) && (n == 0 || q.inflight[n].at.Before(q.lastResult)) {
+		n++
+	}
+	done := make([]Input, n)
+	for i := range done {
+		done[i] = q.inflight[i].in
+	}
+	q.inflight = q.inflight[n:]
+	q.lastResult = time.Now()
+	return done
+}
+
+// unanswered puts inputs whose turn never finished back at the head of the
+// queue for the next manager process
+func (q *inputQueue) unanswered() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.inflight)
+	items := make([]Input, 0, n+len(q.items))
+	for _, s := range q.inflight {
+		items = append(items, s.in)
+	}
+	q.items = append(items, q.items...)
+	q.inflight = nil
+	q.lastResult = time.Time{}
+	return n
+}
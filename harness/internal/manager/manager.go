@@ -3,60 +3,109 @@ package manager
 import (
 	"bufio"
 	"context"
+	_ "embed"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
 	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/privilege"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
 	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
 )
 
-var mmMsgPattern = regexp.MustCompile(`^\[MM:([^\]]+)\]\s*(.*)$`)
+var mmMsgPattern = regexp.MustCompile(`(?s)^\[MM:([^\]]+)\]\s*(.*)$`)
+
+// managerTools are the tools the manager may use without asking. It acts
+// through directives, so it only needs to read the workspace; in print mode
+// nobody can answer a permission prompt, so anything else is refused.
+const managerTools = "Read,Glob,Grep"
+
+// protocol documents the directive protocol; it is appended to the
+// manager's system prompt
+//
+//go:embed protocol.md
+var protocol string
 
 // Manager handles the Claude Code manager agent lifecycle
 type Manager struct {
-	state  *state.AppState
-	mm     *mattermost.Bridge
-	logger *log.Logger
-	cmd    *exec.Cmd
+	state      *state.AppState
+	mm         *mattermost.Bridge
+	spawner    *spawner.Spawner
+	approvals  *approval.Broker
+	privileges *privilege.Service
+	logger     *log.Logger
+	cmd        *exec.Cmd
+	ctx        context.Context
+	inputs     *inputQueue
+
+	mu          sync.Mutex
+	lastMessage string // last assistant message ID whose usage was counted
 }
 
 // New creates a new manager lifecycle handler
-func New(appState *state.AppState, mm *mattermost.Bridge, logger *log.Logger) *Manager {
+func New(appState *state.AppState, mm *mattermost.Bridge, sp *spawner.Spawner, approvals *approval.Broker, privileges *privilege.Service, logger *log.Logger) *Manager {
 	return &Manager{
-		state:  appState,
-		mm:     mm,
-		logger: logger,
+		state:      appState,
+		mm:         mm,
+		spawner:    sp,
+		approvals:  approvals,
+		privileges: privileges,
+		logger:     logger,
+		ctx:        context.Background(),
+		inputs:     newInputQueue(),
 	}
 }
 
 // Run manages the Claude Code process lifecycle
 func (m *Manager) Run(ctx context.Context) {
+	m.ctx = ctx
+	if m.mm != nil {
+		go m.feedMattermost(ctx)
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
-			if err := m.runOnce(ctx); err != nil {
+			started := time.Now()
+			stderr := &tailWriter{}
+			err := m.runOnce(ctx, stderr)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
 				m.logger.Error("Manager process exited", "error", err)
 			}
-			// Brief pause before restart
+			delay := m.exited(started, err, stderr.tail())
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(3 * time.Second):
-				m.logger.Info("Restarting manager agent...")
+			case <-time.After(delay):
+				m.logger.Info("Restarting manager agent...", "after", delay)
 			}
 		}
 	}
 }
 
-func (m *Manager) runOnce(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "claude", "--permission-mode", "default")
+// runOnce runs one manager process until it exits. Its stderr goes to the
+// harness's stderr and to the tail kept for crash reports.
+func (m *Manager) runOnce(ctx context.Context, stderr *tailWriter) error {
+	cmd := exec.CommandContext(ctx, "claude", "-p",
+		"--input-format", "stream-json",
+		"--output-format", "stream-json",
+		"--verbose",
+		"--allowedTools", managerTools,
+		"--append-system-prompt", protocol,
+	)
 	cmd.Dir = findProjectRoot()
 	cmd.Env = append(os.Environ(), "CLAUDE_CONFIG_DIR="+os.Getenv("HOME")+"/.claude")
 
@@ -70,7 +119,7 @@ func (m *Manager) runOnce(ctx context.Context) error {
 		return fmt.Errorf("stdout pipe: %w", err)
 	}
 
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
 	m.cmd = cmd
 
 	if err := cmd.Start(); err != nil {
@@ -80,19 +129,18 @@ func (m *Manager) runOnce(ctx context.Context) error {
 	m.state.SetManagerPID(cmd.Process.Pid)
 	m.logger.Info("Manager started", "pid", cmd.Process.Pid)
 
-	// Feed Mattermost messages to stdin
-	if m.mm != nil {
-		go func() {
-			for msg := range m.mm.Messages() {
-				prompt := fmt.Sprintf("[From MM thread %s, user %s]: %s\n",
-					msg.ThreadID, msg.Username, msg.Text)
-				if _, err := stdin.Write([]byte(prompt)); err != nil {
-					m.logger.Error("Failed to write to manager stdin", "error", err)
-					return
-				}
-			}
-		}()
-	}
+	// A process that stays up clears any crash loop
+	stableTimer := time.AfterFunc(stableAfter, m.stable)
+	defer stableTimer.Stop()
+
+	// Deliver queued input for as long as this process lives
+	procCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		if err := m.inputs.deliver(procCtx, stdin); err != nil {
+			m.logger.Warn("Manager stdin closed; input stays queued", "error", err, "queued", m.inputs.len())
+		}
+	}()
 
 	// Process stdout
 	scanner := bufio.NewScanner(stdout)
@@ -103,41 +151,286 @@ func (m *Manager) runOnce(ctx context.Context) error {
 	}
 
 	m.state.SetManagerPID(0)
+	cancel()
+	if n := m.inputs.unanswered(); n > 0 {
+		m.logger.Warn("Manager exited mid-turn; its input will be sent again", "inputs", n)
+	}
 	return cmd.Wait()
 }
 
+// processOutput handles one stream-json event from the manager. Text blocks
+// carry its directives; tool calls, results and usage feed its stats.
 func (m *Manager) processOutput(line string) {
+	ev, ok := stream.Parse(line)
+	if !ok {
+		m.logger.Debug("Manager output", "line", line)
+		return
+	}
+
+	switch ev.Type {
+	case stream.TypeSystem:
+		if ev.Subtype == "init" {
+			m.logger.Info("Manager session started", "session", ev.SessionID)
+			m.state.UpdateManagerStats(func(st *state.ManagerStats) {
+				st.SessionID = ev.SessionID
+			})
+		}
+	case stream.TypeAssistant:
+		m.recordMessage(ev)
+		for _, block := range ev.TextBlocks() {
+			for _, text := range segments(block) {
+				m.processText(text)
+			}
+		}
+	case stream.TypeResult:
+		m.recordResult(ev)
+		for _, in := range m.inputs.answered() {
+			m.ackInput(in)
+		}
+	}
+}
+
+// recordMessage accounts an assistant message's usage and tool calls.
+// Claude emits one event per content block, each repeating the message's
+// usage, so usage is counted once per message ID.
+func (m *Manager) recordMessage(ev *stream.Event) {
+	if ev.Message == nil {
+		return
+	}
+	calls := ev.ToolCalls()
+	for _, c := range calls {
+		m.logger.Debug("Manager tool call", "tool", c.Name, "id", c.ID)
+	}
+
+	m.mu.Lock()
+	seen := ev.Message.ID != "" && ev.Message.ID == m.lastMessage
+	m.lastMessage = ev.Message.ID
+	m.mu.Unlock()
+
+	m.state.UpdateManagerStats(func(st *state.ManagerStats) {
+		st.LastActivity = time.Now()
+		if ev.Message.Model != "" {
+			st.Model = ev.Message.Model
+		}
+		if !seen {
+			st.Tokens += ev.Message.Usage.Billable()
+		}
+		st.ToolCalls += len(calls)
+		if len(calls) > 0 {
+			st.LastTool = calls[len(calls)-1].Name
+		}
+	})
+}
+
+// recordResult closes a manager turn
+func (m *Manager) recordResult(ev *stream.Event) {
+	if ev.IsError {
+		m.logger.Warn("Manager turn failed", "subtype", ev.Subtype, "result", ev.Result)
+	} else {
+		m.logger.Debug("Manager turn finished", "turns", ev.NumTurns, "duration_ms", ev.DurationMS)
+	}
+	m.state.UpdateManagerStats(func(st *state.ManagerStats) {
+		st.Turns++
+		st.CostUSD += ev.TotalCostUSD
+		st.LastActivity = time.Now()
+		st.LastError = ""
+		if ev.IsError {
+			st.LastError = ev.Result
+		}
+	})
+	if err := m.state.Save(); err != nil {
+		m.logger.Warn("Failed to persist manager stats", "error", err)
+	}
+}
+
+// segments splits a text block into what the harness acts on: each
+// [MM:thread] line with the lines after it, up to the next tagged line or
+// the end of the block, and each directive line. Untagged lines outside a
+// message are the manager thinking aloud and are dropped.
+func segments(block string) []string {
+	var out []string
+	var msg []string
+	flush := func() {
+		if msg != nil {
+			out = append(out, strings.TrimRight(strings.Join(msg, "\n"), " \t\n"))
+			msg = nil
+		}
+	}
+	for _, line := range strings.Split(block, "\n") {
+		switch {
+		case mmMsgPattern.MatchString(line):
+			flush()
+			msg = []string{line}
+		case directivePattern.MatchString(line):
+			flush()
+			out = append(out, line)
+		case msg != nil:
+			msg = append(msg, line)
+		}
+	}
+	flush()
+	return out
+}
+
+// processText acts on one [MM:thread] message or directive line of the
+// manager's text output
+func (m *Manager) processText(line string) {
 	// Check for Mattermost-bound output
 	if matches := mmMsgPattern.FindStringSubmatch(line); len(matches) == 3 {
 		threadID := matches[1]
 		message := matches[2]
 		if m.mm != nil {
-			// Find channel for this thread
-			if err := m.mm.PostMessage("", threadID, message); err != nil {
-				m.logger.Error("Failed to post to Mattermost", "thread", threadID, "error", err)
+			// The bridge resolves the thread's channel
+			m.mm.PostMessage("", threadID, message)
+		}
+		return
+	}
+
+	// Check for harness directives
+	if directivePattern.MatchString(line) {
+		m.handleDirective(line)
+	}
+}
+
+// spawn launches a worker on behalf of the manager agent and reports the
+// outcome to the requesting thread
+func (m *Manager) spawn(req spawner.Request) (*state.Worker, error) {
+	if m.spawner == nil {
+		return nil, fmt.Errorf("no spawner configured")
+	}
+	w, err := m.spawner.Spawn(m.ctx, req)
+	var reply string
+	if err != nil {
+		m.logger.Error("Failed to spawn worker", "project", req.Project, "error", err)
+		reply = fmt.Sprintf("Failed to spawn %s worker for `%s`: %v", req.WorkerType, req.Project, err)
+	} else {
+		reply = fmt.Sprintf("Spawned %s worker `%s` for `%s`", w.WorkerType, w.ID, w.Project)
+	}
+	if m.mm != nil && req.ThreadID != "" {
+		m.mm.PostMessage("", req.ThreadID, reply)
+	}
+	return w, err
+}
+
+// feedMattermost queues director messages for the manager for the lifetime
+// of the harness, independent of manager restarts
+func (m *Manager) feedMattermost(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-m.mm.Messages():
+			if !ok {
+				return
 			}
+			m.handleMattermost(msg)
+		case r, ok := <-m.mm.Reactions():
+			if !ok {
+				return
+			}
+			m.handleReaction(r)
 		}
+	}
+}
+
+// handleMattermost routes one message from the Mattermost inbox
+func (m *Manager) handleMattermost(msg mattermost.Message) {
+	// Director decisions and commands are handled by the harness; only new
+	// posts count, so an edited "approve" does not decide twice
+	if msg.Kind == mattermost.MessagePosted {
+		if m.approvals != nil && m.approvals.HandleMessage(msg) {
+			m.mm.Ack(msg.ID)
+			return
+		}
+		if m.privileges != nil && m.privileges.HandleMessage(msg) {
+			m.mm.Ack(msg.ID)
+			return
+		}
+	}
+	if !m.mm.Authorized(msg, state.RoleDirector) {
+		if msg.Kind == mattermost.MessagePosted {
+			m.mm.Refuse(msg, "steer the manager")
+		}
+		m.mm.Drop(msg.ID)
+		return
+	}
+
+	in := Input{
+		Source:   SourceMattermost,
+		ThreadID: msg.ThreadID,
+		User:     msg.Username,
+		Text:     msg.Text,
+		AckID:    msg.ID,
+	}
+	switch msg.Kind {
+	case mattermost.MessageEdited:
+		in.Kind = KindCorrection
+		if msg.Previous != "" {
+			in.Text = fmt.Sprintf("edited %q to: %s", msg.Previous, msg.Text)
+		} else {
+			in.Text = "edited an earlier message to: " + msg.Text
+		}
+	case mattermost.MessageDeleted:
+		in.Kind = KindRetraction
+		if msg.Previous != "" {
+			in.Text = fmt.Sprintf("deleted %q", msg.Previous)
+		} else {
+			in.Text = "deleted an earlier message"
+		}
+	}
+	// Acknowledged once the manager's turn on it finishes, see ackInput
+	m.Enqueue(in)
+}
+
+// handleReaction treats reactions on approval prompts as decisions and
+// passes directors' other reactions to the manager as a light signal
+func (m *Manager) handleReaction(r mattermost.Reaction) {
+	if m.approvals != nil && m.approvals.HandleReaction(r) {
+		m.mm.Ack(r.ID)
 		return
 	}
+	if !m.mm.Authorized(r.Message(), state.RoleDirector) {
+		m.mm.Drop(r.ID)
+		return
+	}
+	m.Enqueue(Input{
+		Source:   SourceMattermost,
+		Kind:     KindReaction,
+		ThreadID: r.ThreadID,
+		User:     r.Username,
+		Text:     fmt.Sprintf(":%s: on a bot post in this thread", r.Emoji),
+		AckID:    r.ID,
+	})
+}
 
-	// Check for privilege requests from workers
-	if strings.HasPrefix(line, "[PRIVILEGE_REQUEST]") {
-		m.logger.Info("Privilege request from worker", "request", line)
+// ackInput acknowledges a Mattermost post once the manager has answered it
+func (m *Manager) ackInput(in Input) {
+	if m.mm != nil && in.AckID != "" {
+		m.mm.Ack(in.AckID)
 	}
+}
 
-	// Log other output
-	m.logger.Debug("Manager output", "line", line)
+// Enqueue queues input for the manager's stdin. Input is delivered in
+// order, and held while the manager is down until it restarts.
+func (m *Manager) Enqueue(in Input) {
+	if in.QueuedAt.IsZero() {
+		in.QueuedAt = time.Now()
+	}
+	m.inputs.push(in)
+	m.logger.Debug("Manager input queued", "source", in.Source, "thread", in.ThreadID, "queued", m.inputs.len())
 }
 
-// SendMessage sends a message to the manager's stdin
+// SendMessage queues a harness message for the manager
 func (m *Manager) SendMessage(msg string) error {
-	if m.cmd == nil || m.cmd.Process == nil {
-		return fmt.Errorf("manager not running")
-	}
-	// This is a simplified version — in production, use the stdin pipe
+	m.Enqueue(Input{Source: SourceHarness, Text: msg})
 	return nil
 }
 
+// QueuedInputs returns how many inputs are waiting for the manager
+func (m *Manager) QueuedInputs() int {
+	return m.inputs.len()
+}
+
 func findProjectRoot() string {
 	// Look for workspace.yaml to find the project root
 	candidates := []string{
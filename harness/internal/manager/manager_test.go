@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  []string
+	}{
+		{
+			name:  "one line",
+			block: "[MM:t1] Done.",
+			want:  []string{"[MM:t1] Done."},
+		},
+		{
+			name:  "message over several lines",
+			block: "[MM:t1] Two workers are running:\n\n- `api-1a2b3c` on the fix\n- `web-4d5e6f` on the docs\n",
+			want:  []string{"[MM:t1] Two workers are running:\n\n- `api-1a2b3c` on the fix\n- `web-4d5e6f` on the docs"},
+		},
+		{
+			name:  "ends at the next message",
+			block: "[MM:t1] First\nmore\n[MM:t2] Second",
+			want:  []string{"[MM:t1] First\nmore", "[MM:t2] Second"},
+		},
+		{
+			name:  "ends at a directive",
+			block: "[MM:t1] Spawning now.\n" + `[DIRECTIVE v1] {"id":"d1","op":"status"}` + "\ntrailing thought",
+			want:  []string{"[MM:t1] Spawning now.", `[DIRECTIVE v1] {"id":"d1","op":"status"}`},
+		},
+		{
+			name:  "untagged text is dropped",
+			block: "Let me check the workers.\n" + `[DIRECTIVE v1] {"id":"d1","op":"status"}`,
+			want:  []string{`[DIRECTIVE v1] {"id":"d1","op":"status"}`},
+		},
+		{
+			name:  "nothing tagged",
+			block: "Thinking about it.\nStill thinking.",
+		},
+	}
+	for _, tt := range tests {
+		if got := segments(tt.block); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: segments = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMMMessagePattern(t *testing.T) {
+	m := mmMsgPattern.FindStringSubmatch("[MM:t1] Two workers:\n- one\n- two")
+	if len(m) != 3 || m[1] != "t1" || m[2] != "Two workers:\n- one\n- two" {
+		t.Errorf("mmMsgPattern = %q", m)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -23,17 +22,40 @@ type Bridge struct {
 	logger   *log.Logger
 	conn     *websocket.Conn
 	mu       sync.Mutex
+	self     identity
+	users    *Directory
 	msgCh    chan Message
+	client   *http.Client
+
+	outMu       sync.Mutex
+	deliveries  map[string]*Delivery // outbox ID -> waiting caller
+	outSignal   chan struct{}
+	pausedUntil time.Time // set by rate limits
+
+	inSignal chan struct{} // new posts in the inbox
+	reactCh  chan Reaction
+	events   chan []byte // WebSocket events waiting to be handled, in order
+
+	recent      map[string]string // post ID -> text, for recently heard posts
+	recentOrder []string
 }
 
-// Message represents an incoming Mattermost message
+// Message represents an incoming Mattermost message, or an edit or
+// deletion of one
 type Message struct {
+	// ID is the inbox entry to Ack or Drop; the post ID for new posts
+	ID        string
+	Kind      MessageKind
 	ThreadID  string
 	ChannelID string
 	UserID    string
 	Username  string
 	Text      string
 	PostID    string
+	// DisplayName is the sender's full name or nickname
+	DisplayName string
+	// Previous is the text before an edit or deletion, when it was heard
+	Previous string
 }
 
 // NewBridge creates a new Mattermost bridge
@@ -41,23 +63,44 @@ func NewBridge(wsURL, apiURL, botToken string, appState *state.AppState, logger
 	if wsURL == "" || botToken == "" {
 		return nil, fmt.Errorf("MM_WS_URL and MM_BOT_TOKEN are required")
 	}
-	return &Bridge{
+	b := &Bridge{
 		wsURL:    wsURL,
 		apiURL:   apiURL,
 		botToken: botToken,
 		state:    appState,
 		logger:   logger,
-		msgCh:    make(chan Message, 100),
-	}, nil
+		msgCh:    make(chan Message),
+		client:   &http.Client{Timeout: apiTimeout},
+
+		deliveries: make(map[string]*Delivery),
+		outSignal:  make(chan struct{}, 1),
+		inSignal:   make(chan struct{}, 1),
+		reactCh:    make(chan Reaction),
+		events:     make(chan []byte, 256),
+		recent:     make(map[string]string),
+	}
+	b.users = newDirectory(b)
+	return b, nil
+}
+
+// Users returns the bridge's user directory
+func (b *Bridge) Users() *Directory {
+	return b.users
 }
 
-// Messages returns the channel of incoming messages
+// Messages returns the channel of incoming messages. Each must be
+// acknowledged with Ack or Drop once consumed, or it is delivered again
+// after a restart.
 func (b *Bridge) Messages() <-chan Message {
 	return b.msgCh
 }
 
-// Run connects to Mattermost WebSocket and processes events
+// Run connects to Mattermost WebSocket and processes events, hands out
+// inbox posts and sends queued posts
 func (b *Bridge) Run(ctx context.Context) {
+	go b.dispatch(ctx)
+	go b.deliver(ctx)
+	go b.handleEvents(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -78,6 +121,17 @@ func (b *Bridge) Run(ctx context.Context) {
 }
 
 func (b *Bridge) connect(ctx context.Context) error {
+	// Know who we are before listening, so our own posts are never
+	// forwarded back to the manager
+	self, err := b.identify()
+	if err != nil {
+		return fmt.Errorf("identify: %w", err)
+	}
+	b.mu.Lock()
+	b.self = self
+	b.mu.Unlock()
+	b.logger.Info("Mattermost bot identified", "user", self.username, "channel", b.state.Config().Mattermost.Channel, "channel_id", self.channelID)
+
 	wsURL := b.wsURL + "/api/v4/websocket"
 	header := http.Header{}
 	header.Set("Authorization", "Bearer "+b.botToken)
@@ -113,7 +167,24 @@ func (b *Bridge) connect(ctx context.Context) error {
 			if err != nil {
 				return fmt.Errorf("read: %w", err)
 			}
-			b.handleEvent(rawMsg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case b.events <- rawMsg:
+			}
+		}
+	}
+}
+
+// handleEvents handles WebSocket events in the order they arrived, away
+// from the read loop, since each may wait on the REST API
+func (b *Bridge) handleEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case raw := <-b.events:
+			b.handleEvent(raw)
 		}
 	}
 }
@@ -127,7 +198,19 @@ func (b *Bridge) handleEvent(raw []byte) {
 		return
 	}
 
-	if event.Event != "posted" {
+	var kind MessageKind
+	switch event.Event {
+	case "posted":
+		kind = MessagePosted
+	case "post_edited":
+		kind = MessageEdited
+	case "post_deleted":
+		kind = MessageDeleted
+	case "reaction_added":
+		// Looking up the reacted post must not hold up the event stream
+		go b.handleReaction(event.Data)
+		return
+	default:
 		return
 	}
 
@@ -135,6 +218,11 @@ func (b *Bridge) handleEvent(raw []byte) {
 	if !ok {
 		return
 	}
+	channelType, _ := event.Data["channel_type"].(string)
+	var mentions []string
+	if raw, ok := event.Data["mentions"].(string); ok {
+		json.Unmarshal([]byte(raw), &mentions)
+	}
 
 	var post struct {
 		ID        string `json:"id"`
@@ -142,6 +230,7 @@ func (b *Bridge) handleEvent(raw []byte) {
 		UserID    string `json:"user_id"`
 		Message   string `json:"message"`
 		RootID    string `json:"root_id"`
+		EditAt    int64  `json:"edit_at"`
 	}
 	if err := json.Unmarshal([]byte(postJSON), &post); err != nil {
 		return
@@ -152,48 +241,104 @@ func (b *Bridge) handleEvent(raw []byte) {
 		threadID = post.ID
 	}
 
+	// Edits and deletions follow the post they change; edit events carry
+	// no mentions or channel type to judge them by
+	b.mu.Lock()
+	_, known := b.recent[post.ID]
+	b.mu.Unlock()
+	if !known && !b.forward(post.UserID, post.ChannelID, channelType, post.Message, mentions) {
+		return
+	}
+	b.remember(threadID, post.ChannelID)
+
 	msg := Message{
+		ID:        post.ID,
+		Kind:      kind,
 		ThreadID:  threadID,
 		ChannelID: post.ChannelID,
 		UserID:    post.UserID,
 		Text:      post.Message,
 		PostID:    post.ID,
 	}
+	switch kind {
+	case MessageEdited:
+		msg.ID = fmt.Sprintf("%s:edit:%d", post.ID, post.EditAt)
+	case MessageDeleted:
+		msg.ID = post.ID + ":delete"
+		msg.Text = ""
+	}
+	// Roles are only granted to the username the API resolves; the event's
+	// sender_name can be overridden by webhooks, so it is never used
+	if u, err := b.users.User(post.UserID); err == nil {
+		msg.Username = u.Username
+		msg.DisplayName = u.DisplayName()
+	} else {
+		b.logger.Warn("Could not resolve Mattermost user; treating them as having no role", "user", post.UserID, "error", err)
+	}
 
-	select {
-	case b.msgCh <- msg:
-	default:
-		b.logger.Warn("Message channel full, dropping message")
+	// People without any role are not heard at all, and were already
+	// told so about the post they now change
+	if !b.Authorized(msg, state.RoleObserver) {
+		if kind == MessagePosted {
+			b.Refuse(msg, "talk to the workspace agent")
+		}
+		return
 	}
-}
 
-// PostMessage sends a message to a Mattermost channel/thread
-func (b *Bridge) PostMessage(channelID, rootID, message string) error {
-	url := b.apiURL + "/api/v4/posts"
-	body := map[string]string{
-		"channel_id": channelID,
-		"message":    message,
+	if prev, ok := b.heard(post.ID, msg.Text); ok && kind != MessagePosted {
+		msg.Previous = prev
 	}
-	if rootID != "" {
-		body["root_id"] = rootID
+	b.receive(msg)
+}
+
+// Notify queues a message for a thread. It does nothing without a thread
+// or when Mattermost is not configured, i.e. on a nil Bridge.
+func (b *Bridge) Notify(threadID, message string) {
+	if b == nil || threadID == "" {
+		return
 	}
+	b.PostMessage("", threadID, message)
+}
 
-	data, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(data)))
-	if err != nil {
-		return err
+// PostMessage queues a message for a Mattermost channel/thread. An empty
+// channel ID is resolved from the thread registry. Messages over the
+// configured upload threshold are attached as a file, or split into
+// several posts if the upload fails.
+func (b *Bridge) PostMessage(channelID, rootID, message string) *Delivery {
+	if threshold := b.state.Config().Mattermost.UploadThreshold; threshold > 0 && len(message) > threshold {
+		return b.postLong(channelID, rootID, message)
 	}
-	req.Header.Set("Authorization", "Bearer "+b.botToken)
-	req.Header.Set("Content-Type", "application/json")
+	return b.Send(Post{ChannelID: channelID, RootID: rootID, Message: message})
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+// ResolveChannel returns the channel to post in: the given one, the
+// channel the thread was last seen in, or the configured channel for new
+// top-level posts. Every outbound post and upload goes through it.
+func (b *Bridge) ResolveChannel(channelID, rootID string) (string, error) {
+	if channelID != "" {
+		return channelID, nil
+	}
+	if rootID != "" {
+		if ch := b.state.ThreadChannel(rootID); ch != "" {
+			return ch, nil
+		}
+		return "", fmt.Errorf("no channel known for thread %s", rootID)
 	}
-	defer resp.Body.Close()
+	b.mu.Lock()
+	channelID = b.self.channelID
+	b.mu.Unlock()
+	if channelID == "" {
+		return "", fmt.Errorf("no channel or thread to post to")
+	}
+	return channelID, nil
+}
 
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("mattermost API returned %d", resp.StatusCode)
+// remember records a thread's channel in the persisted registry
+func (b *Bridge) remember(threadID, channelID string) {
+	if !b.state.RememberThread(threadID, channelID) {
+		return
+	}
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist thread registry", "error", err)
 	}
-	return nil
 }
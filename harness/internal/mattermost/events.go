@@ -0,0 +1,131 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// MessageKind says what happened to a post
+type MessageKind string
+
+const (
+	// MessagePosted is a new post
+	MessagePosted MessageKind = ""
+	// MessageEdited is a correction of an earlier post
+	MessageEdited MessageKind = "edited"
+	// MessageDeleted is a retraction of an earlier post
+	MessageDeleted MessageKind = "deleted"
+	// messageReacted is a reaction waiting in the inbox; it is handed out
+	// on Reactions, not Messages
+	messageReacted MessageKind = "reacted"
+)
+
+// recentPosts is how many heard posts are remembered, so edits and
+// deletions can quote what they change
+const recentPosts = 500
+
+// Reaction is an emoji reaction someone added to one of the bot's posts
+type Reaction struct {
+	// ID is the inbox entry to Ack or Drop
+	ID        string
+	PostID    string
+	ThreadID  string
+	ChannelID string
+	UserID    string
+	Username  string
+	Emoji     string // name without colons, e.g. "+1"
+}
+
+// Reactions returns the channel of reactions to the bot's posts. Like
+// messages, each must be acknowledged with Ack or Drop.
+func (b *Bridge) Reactions() <-chan Reaction {
+	return b.reactCh
+}
+
+// heard records the text of a post forwarded to the harness and returns
+// the text it had before, if it was heard earlier
+func (b *Bridge) heard(postID, text string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prev, ok := b.recent[postID]
+	if !ok {
+		b.recentOrder = append(b.recentOrder, postID)
+		if len(b.recentOrder) > recentPosts {
+			delete(b.recent, b.recentOrder[0])
+			b.recentOrder = b.recentOrder[1:]
+		}
+	}
+	b.recent[postID] = text
+	return prev, ok
+}
+
+// handleReaction puts reactions to the bot's posts from people with a role
+// in the inbox. Reactions are lightweight signals, so unauthorized ones are
+// ignored without a reply.
+func (b *Bridge) handleReaction(data map[string]interface{}) {
+	raw, ok := data["reaction"].(string)
+	if !ok {
+		return
+	}
+	var reaction struct {
+		UserID    string `json:"user_id"`
+		PostID    string `json:"post_id"`
+		EmojiName string `json:"emoji_name"`
+	}
+	if err := json.Unmarshal([]byte(raw), &reaction); err != nil {
+		return
+	}
+	b.mu.Lock()
+	self := b.self.userID
+	b.mu.Unlock()
+	// The bot's own receipts are reactions too
+	if reaction.UserID == self {
+		return
+	}
+
+	var post struct {
+		ID        string `json:"id"`
+		UserID    string `json:"user_id"`
+		ChannelID string `json:"channel_id"`
+		RootID    string `json:"root_id"`
+	}
+	if err := b.get("/api/v4/posts/"+reaction.PostID, &post); err != nil {
+		b.logger.Warn("Could not look up reacted post", "post", reaction.PostID, "error", err)
+		return
+	}
+	if post.UserID != self {
+		return
+	}
+
+	r := Reaction{
+		PostID:    post.ID,
+		ThreadID:  post.RootID,
+		ChannelID: post.ChannelID,
+		UserID:    reaction.UserID,
+		Emoji:     strings.Trim(reaction.EmojiName, ":"),
+	}
+	if r.ThreadID == "" {
+		r.ThreadID = post.ID
+	}
+	if u, err := b.users.User(reaction.UserID); err == nil {
+		r.Username = u.Username
+	}
+	if !b.Authorized(r.Message(), state.RoleObserver) {
+		return
+	}
+
+	msg := r.Message()
+	msg.ID = fmt.Sprintf("%s:react:%s:%s", r.PostID, r.UserID, r.Emoji)
+	msg.Kind = messageReacted
+	msg.Text = r.Emoji
+	b.receive(msg)
+}
+
+// Message describes the reaction's sender and thread, for authorization
+// and replies
+func (r Reaction) Message() Message {
+	return Message{ThreadID: r.ThreadID, ChannelID: r.ChannelID, UserID: r.UserID, Username: r.Username, PostID: r.PostID}
+}
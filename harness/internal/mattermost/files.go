@@ -0,0 +1,109 @@
+package mattermost
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Small text files are also shown inline, up to this size
+const (
+	previewMaxBytes = 2000
+	previewMaxLines = 30
+)
+
+// UploadFile uploads a file to a channel through the files API and returns
+// its file ID, to be attached to a post in the same channel. It tries once;
+// posts with Files are uploaded and retried by the outbox.
+func (b *Bridge) UploadFile(channelID, name string, data []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("channel_id", channelID)
+	part, err := mw.CreateFormFile("files", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	var uploaded struct {
+		FileInfos []struct {
+			ID string `json:"id"`
+		} `json:"file_infos"`
+	}
+	req, err := http.NewRequest("POST", b.apiURL+"/api/v4/files", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := b.do(req, &uploaded); err != nil {
+		return "", err
+	}
+	if len(uploaded.FileInfos) == 0 {
+		return "", fmt.Errorf("upload %s: no file returned", name)
+	}
+	return uploaded.FileInfos[0].ID, nil
+}
+
+// uploadSpooled uploads a queued post's file from the outbox directory
+func (b *Bridge) uploadSpooled(channelID string, f state.OutboundFile) (string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return "", err
+	}
+	return b.UploadFile(channelID, f.Name, data)
+}
+
+// PostFile queues message for a channel/thread with a file attached. Small
+// text files are previewed inline under the message as well. If the upload
+// fails the message is posted alone, noting the missing file.
+func (b *Bridge) PostFile(channelID, rootID, message, name string, data []byte) *Delivery {
+	fallback := message + fmt.Sprintf(" (upload of `%s` failed)", name)
+	if preview, ok := textPreview(data); ok {
+		message = strings.TrimSpace(message + "\n```\n" + preview + "\n```")
+	}
+	return b.Send(Post{ChannelID: channelID, RootID: rootID, Message: message,
+		Files: []File{{Name: name, Data: data}}, Fallback: fallback})
+}
+
+// textPreview returns data for inline display when it is short text
+func textPreview(data []byte) (string, bool) {
+	if len(data) == 0 || len(data) > previewMaxBytes || !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return "", false
+	}
+	text := strings.TrimRight(string(data), "\n")
+	if strings.Count(text, "\n")+1 > previewMaxLines || strings.Contains(text, "```") {
+		return "", false
+	}
+	return text, true
+}
+
+// postLong attaches a message over the upload threshold as a file, posting
+// its opening lines as a summary, or the whole message in parts if the
+// upload fails
+func (b *Bridge) postLong(channelID, rootID, message string) *Delivery {
+	summary := message
+	if len(summary) > previewMaxBytes {
+		summary = summary[:previewMaxBytes]
+		if i := strings.LastIndex(summary, "\n"); i > 0 {
+			summary = summary[:i]
+		}
+	}
+	// An opened code fence would swallow the note below it
+	if strings.Count(summary, "```")%2 == 1 {
+		summary += "\n```"
+	}
+	summary += fmt.Sprintf("\n\n_Message truncated; the full %d characters are attached._", len(message))
+	return b.Send(Post{ChannelID: channelID, RootID: rootID, Message: summary,
+		Files: []File{{Name: "message.md", Data: []byte(message)}}, Fallback: message})
+}
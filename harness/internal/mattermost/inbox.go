@@ -0,0 +1,155 @@
+package mattermost
+
+import (
+	"context"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Reactions the bot leaves on posts it hears
+const (
+	reactionReceived  = "eyes"
+	reactionProcessed = "white_check_mark"
+)
+
+// receive writes a post, edit, deletion or reaction to the durable inbox
+// and marks new posts and edits as received. Nothing is dropped: the entry
+// waits in the inbox until it is acknowledged.
+func (b *Bridge) receive(msg Message) {
+	added := b.state.AddInbound(&state.Inbound{
+		ID:          msg.ID,
+		Kind:        string(msg.Kind),
+		PostID:      msg.PostID,
+		ThreadID:    msg.ThreadID,
+		ChannelID:   msg.ChannelID,
+		UserID:      msg.UserID,
+		Username:    msg.Username,
+		DisplayName: msg.DisplayName,
+		Text:        msg.Text,
+		Previous:    msg.Previous,
+		ReceivedAt:  time.Now(),
+	})
+	if !added {
+		return
+	}
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost inbox", "error", err)
+	}
+	if msg.Kind == MessagePosted || msg.Kind == MessageEdited {
+		b.react(msg.PostID, reactionReceived)
+	}
+	select {
+	case b.inSignal <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch hands inbox entries to Messages, or Reactions, in order, each
+// once per run. Entries left unacknowledged by a restart are handed out
+// again.
+func (b *Bridge) dispatch(ctx context.Context) {
+	handed := make(map[string]bool)
+	for {
+		pending := b.state.PendingInbound()
+		still := make(map[string]bool, len(pending))
+		for _, in := range pending {
+			still[in.ID] = handed[in.ID]
+		}
+		handed = still
+
+		for _, in := range pending {
+			if handed[in.ID] {
+				continue
+			}
+			if MessageKind(in.Kind) == messageReacted {
+				r := Reaction{
+					ID:        in.ID,
+					PostID:    in.PostID,
+					ThreadID:  in.ThreadID,
+					ChannelID: in.ChannelID,
+					UserID:    in.UserID,
+					Username:  in.Username,
+					Emoji:     in.Text,
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case b.reactCh <- r:
+					handed[in.ID] = true
+				}
+				continue
+			}
+			msg := Message{
+				ID:          in.ID,
+				Kind:        MessageKind(in.Kind),
+				ThreadID:    in.ThreadID,
+				ChannelID:   in.ChannelID,
+				UserID:      in.UserID,
+				Username:    in.Username,
+				Text:        in.Text,
+				PostID:      in.PostID,
+				DisplayName: in.DisplayName,
+				Previous:    in.Previous,
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case b.msgCh <- msg:
+				handed[in.ID] = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.inSignal:
+		}
+	}
+}
+
+// Ack removes a consumed message from the inbox by its ID and marks its
+// post as processed
+func (b *Bridge) Ack(id string) {
+	in := b.remove(id)
+	if in == nil || (in.Kind != string(MessagePosted) && in.Kind != string(MessageEdited)) {
+		return
+	}
+	b.react(in.PostID, reactionProcessed)
+}
+
+// Drop removes a message the harness declined to act on from the inbox,
+// without marking it as processed
+func (b *Bridge) Drop(id string) {
+	b.remove(id)
+}
+
+func (b *Bridge) remove(id string) *state.Inbound {
+	if id == "" {
+		return nil
+	}
+	in := b.state.RemoveInbound(id)
+	if in == nil {
+		return nil
+	}
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost inbox", "error", err)
+	}
+	return in
+}
+
+// react adds the bot's emoji reaction to a post in the background
+func (b *Bridge) react(postID, emoji string) {
+	b.mu.Lock()
+	userID := b.self.userID
+	b.mu.Unlock()
+	if postID == "" || userID == "" {
+		return
+	}
+	go func() {
+		body := map[string]string{"user_id": userID, "post_id": postID, "emoji_name": emoji}
+		if err := b.call("POST", "/api/v4/reactions", body, nil); err != nil {
+			b.logger.Warn("Failed to react to Mattermost post", "post", postID, "emoji", emoji, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,490 @@
+package mattermost
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+const (
+	// postLimit keeps posts under Mattermost's 16383 character limit
+	postLimit = 16000
+	// apiTimeout bounds every Mattermost REST call
+	apiTimeout = 30 * time.Second
+
+	// A post is retried with doubling delays, then given up on
+	sendAttempts = 8
+	retryBase    = 2 * time.Second
+	retryMax     = 5 * time.Minute
+
+	// An upload is given up on sooner, since the post can fall back to text
+	uploadAttempts = 3
+)
+
+const fenceClose = "\n```"
+
+// Delivery reports what became of a queued post
+type Delivery struct {
+	ID string
+
+	mu      sync.Mutex
+	done    chan struct{}
+	status  state.OutboundStatus
+	postIDs []string
+	err     error
+}
+
+func newDelivery(id string) *Delivery {
+	return &Delivery{ID: id, done: make(chan struct{}), status: state.OutboundPending}
+}
+
+func (d *Delivery) finish(status state.OutboundStatus, postIDs []string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.status != state.OutboundPending {
+		return
+	}
+	d.status = status
+	d.postIDs = postIDs
+	d.err = err
+	close(d.done)
+}
+
+// Done is closed once the post is delivered or given up on
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait blocks until the post is delivered or given up on and returns why
+// it failed, if it did
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.done:
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// Status returns the delivery state without blocking
+func (d *Delivery) Status() state.OutboundStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// PostID returns the ID of the delivered post. A split message returns
+// its last part, which carries any attachments and files.
+func (d *Delivery) PostID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.postIDs) == 0 {
+		return ""
+	}
+	return d.postIDs[len(d.postIDs)-1]
+}
+
+// apiError is a Mattermost API call that got an error status
+type apiError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("mattermost API returned %d", e.status)
+}
+
+// uploadError is a failed upload of a queued post's attachment
+type uploadError struct {
+	name string
+	err  error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("upload %s: %v", e.name, e.err)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether the call may succeed later: rate limits and
+// server errors; anything else is the request's fault
+func (e *apiError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as a date
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(h)); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// Send queues a post. The outbox survives restarts, uploads the post's
+// files, splits messages over Mattermost's length limit and retries failed
+// posts in order per thread.
+func (b *Bridge) Send(p Post) *Delivery {
+	now := time.Now()
+	o := &state.Outbound{
+		ID:          newDeliveryID(),
+		ChannelID:   p.ChannelID,
+		RootID:      p.RootID,
+		Parts:       splitMessage(p.Message, postLimit),
+		FileIDs:     p.FileIDs,
+		Status:      state.OutboundPending,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+	if len(p.Attachments) > 0 {
+		o.Props = map[string]interface{}{"attachments": p.Attachments}
+	}
+	if len(p.Files) > 0 {
+		if p.Fallback != "" {
+			o.Fallback = splitMessage(p.Fallback, postLimit)
+		}
+		if err := b.spool(o, p.Files); err != nil {
+			b.logger.Warn("Failed to spool Mattermost attachment; posting text instead", "thread", p.RootID, "error", err)
+			b.unspool(o)
+			o.Files = nil
+			if o.Fallback != nil {
+				o.Parts, o.Fallback = o.Fallback, nil
+			}
+		}
+	}
+	d := newDelivery(o.ID)
+	b.outMu.Lock()
+	b.deliveries[o.ID] = d
+	b.outMu.Unlock()
+
+	b.state.Enqueue(o)
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost outbox", "error", err)
+	}
+	b.wakeOutbox()
+	return d
+}
+
+// DeliveryStatus looks up a queued post by delivery ID, including posts
+// queued before a restart. Finished posts are kept for an hour.
+func (b *Bridge) DeliveryStatus(id string) (state.OutboundStatus, bool) {
+	o := b.state.GetOutbound(id)
+	if o == nil {
+		return "", false
+	}
+	return o.Status, true
+}
+
+func (b *Bridge) wakeOutbox() {
+	select {
+	case b.outSignal <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends queued posts until ctx ends
+func (b *Bridge) deliver(ctx context.Context) {
+	for {
+		wait := b.sendDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.outSignal:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sendDue sends every queued post that is due and not behind an earlier
+// post to the same thread, and returns how long until the next one is due
+func (b *Bridge) sendDue() time.Duration {
+	next := time.Minute
+	blocked := make(map[string]bool)
+	for _, o := range b.state.PendingOutbound() {
+		key := o.Key()
+		if blocked[key] {
+			continue
+		}
+		b.outMu.Lock()
+		paused := time.Until(b.pausedUntil)
+		b.outMu.Unlock()
+		if paused > 0 {
+			return min(next, paused)
+		}
+		if wait := time.Until(o.NextAttempt); wait > 0 {
+			blocked[key] = true
+			next = min(next, wait)
+			continue
+		}
+		if wait, ok := b.send(&o); !ok {
+			blocked[key] = true
+			next = min(next, wait)
+		}
+	}
+	return next
+}
+
+// send posts the remaining parts of a queued post, recording progress so
+// a retry resumes where it stopped. On failure it returns the retry delay.
+func (b *Bridge) send(o *state.Outbound) (time.Duration, bool) {
+	channelID, err := b.ResolveChannel(o.ChannelID, o.RootID)
+	if err != nil {
+		return b.sendFailed(o, err)
+	}
+	// Files go up before any part is posted, so a post whose upload fails
+	// can still fall back to text
+	fileIDs := append([]string(nil), o.FileIDs...)
+	for i := range o.Files {
+		if o.Files[i].ID == "" {
+			id, err := b.uploadSpooled(channelID, o.Files[i])
+			if err != nil {
+				return b.sendFailed(o, &uploadError{name: o.Files[i].Name, err: err})
+			}
+			files := append([]state.OutboundFile(nil), o.Files...)
+			files[i].ID = id
+			o.Files = files
+			b.state.UpdateOutbound(o.ID, func(so *state.Outbound) {
+				so.Files = files
+			})
+		}
+		fileIDs = append(fileIDs, o.Files[i].ID)
+	}
+	for o.Sent < len(o.Parts) {
+		body := map[string]interface{}{
+			"channel_id": channelID,
+			"message":    o.Parts[o.Sent],
+		}
+		if o.RootID != "" {
+			body["root_id"] = o.RootID
+		}
+		// Files and attachments go with the last part, after the text
+		if o.Sent == len(o.Parts)-1 {
+			if len(fileIDs) > 0 {
+				body["file_ids"] = fileIDs
+			}
+			if len(o.Props) > 0 {
+				body["props"] = o.Props
+			}
+		}
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err := b.call("POST", "/api/v4/posts", body, &created); err != nil {
+			return b.sendFailed(o, err)
+		}
+		// A new root post starts a thread replies will need to find
+		if o.RootID == "" {
+			b.remember(created.ID, channelID)
+		}
+		o.Sent++
+		o.PostIDs = append(o.PostIDs, created.ID)
+		sent, postIDs := o.Sent, o.PostIDs
+		b.state.UpdateOutbound(o.ID, func(so *state.Outbound) {
+			so.Sent = sent
+			so.PostIDs = postIDs
+		})
+	}
+	b.finishSend(o, state.OutboundDelivered, nil)
+	return 0, true
+}
+
+// sendFailed schedules a retry, honoring Retry-After on rate limits, or
+// gives up on errors that will not go away
+func (b *Bridge) sendFailed(o *state.Outbound, err error) (time.Duration, bool) {
+	var apiErr *apiError
+	var upErr *uploadError
+	permanent := errors.As(err, &apiErr) && !apiErr.retryable()
+	attempts := o.Attempts + 1
+	if errors.As(err, &upErr) && o.Fallback != nil && (permanent || attempts >= uploadAttempts) {
+		return b.fallBack(o, err)
+	}
+	if permanent || attempts >= sendAttempts {
+		b.finishSend(o, state.OutboundFailed, err)
+		return 0, false
+	}
+
+	delay := retryBase << (attempts - 1)
+	if delay > retryMax {
+		delay = retryMax
+	}
+	if apiErr != nil && apiErr.status == http.StatusTooManyRequests {
+		if apiErr.retryAfter > 0 {
+			delay = apiErr.retryAfter
+		}
+		// Rate limits apply to the bot, not the thread
+		b.outMu.Lock()
+		b.pausedUntil = time.Now().Add(delay)
+		b.outMu.Unlock()
+	}
+	b.logger.Warn("Mattermost post failed; retrying", "thread", o.RootID, "attempt", attempts, "retry_in", delay, "error", err)
+	b.state.UpdateOutbound(o.ID, func(so *state.Outbound) {
+		so.Attempts = attempts
+		so.NextAttempt = time.Now().Add(delay)
+		so.LastError = err.Error()
+	})
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost outbox", "error", err)
+	}
+	return delay, false
+}
+
+// fallBack gives up on a post's files and queues its text-only fallback in
+// their place, to go out right away
+func (b *Bridge) fallBack(o *state.Outbound, err error) (time.Duration, bool) {
+	b.logger.Warn("Failed to upload Mattermost attachment; posting text instead", "thread", o.RootID, "error", err)
+	b.unspool(o)
+	b.state.UpdateOutbound(o.ID, func(so *state.Outbound) {
+		so.Parts, so.Fallback = so.Fallback, nil
+		so.Files = nil
+		so.Attempts = 0
+		so.NextAttempt = time.Now()
+		so.LastError = err.Error()
+	})
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost outbox", "error", err)
+	}
+	return 0, false
+}
+
+// finishSend records a post's outcome and tells whoever is waiting on it
+func (b *Bridge) finishSend(o *state.Outbound, status state.OutboundStatus, err error) {
+	if err != nil {
+		b.logger.Error("Failed to post to Mattermost", "thread", o.RootID, "channel", o.ChannelID, "attempts", o.Attempts+1, "error", err)
+	}
+	b.unspool(o)
+	b.state.UpdateOutbound(o.ID, func(so *state.Outbound) {
+		so.Status = status
+		so.FinishedAt = time.Now()
+		so.Files = nil
+		if err != nil {
+			so.LastError = err.Error()
+		}
+	})
+	if err := b.state.Save(); err != nil {
+		b.logger.Warn("Failed to persist Mattermost outbox", "error", err)
+	}
+
+	b.outMu.Lock()
+	d := b.deliveries[o.ID]
+	delete(b.deliveries, o.ID)
+	b.outMu.Unlock()
+	if d != nil {
+		d.finish(status, o.PostIDs, err)
+	}
+}
+
+// spool writes a post's files to the outbox directory until they are
+// uploaded
+func (b *Bridge) spool(o *state.Outbound, files []File) error {
+	dir := b.state.OutboxDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for i, f := range files {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d", o.ID, i))
+		o.Files = append(o.Files, state.OutboundFile{Name: f.Name, Path: path})
+		if err := os.WriteFile(path, f.Data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unspool removes a post's spooled files
+func (b *Bridge) unspool(o *state.Outbound) {
+	for _, f := range o.Files {
+		os.Remove(f.Path)
+	}
+}
+
+// splitMessage breaks text into posts of at most limit bytes at line
+// boundaries. A code block cut in two is closed at the end of one part
+// and reopened, with its language, at the start of the next.
+func splitMessage(text string, limit int) []string {
+	if len(text) <= limit {
+		return []string{text}
+	}
+	var parts []string
+	var cur strings.Builder
+	fence := "" // opening line of the code block being written, if any
+	flush := func() {
+		part := strings.TrimRight(cur.String(), "\n")
+		if fence != "" {
+			part += fenceClose
+		}
+		parts = append(parts, part)
+		cur.Reset()
+		if fence != "" {
+			cur.WriteString(fence + "\n")
+		}
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		// A closing fence line needs no room for another
+		reserve := len(fenceClose)
+		if fence != "" && strings.HasPrefix(trimmed, "```") {
+			reserve = 0
+		}
+		for _, piece := range chop(line, limit-len(fence)-2*len(fenceClose)) {
+			if cur.Len() > 0 && cur.Len()+len(piece)+reserve > limit {
+				flush()
+			}
+			cur.WriteString(piece)
+		}
+		if strings.HasPrefix(trimmed, "```") {
+			if fence == "" {
+				fence = trimmed
+			} else {
+				fence = ""
+			}
+		}
+	}
+	if last := strings.TrimRight(cur.String(), "\n"); last != "" {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// chop cuts s into pieces of at most n bytes without splitting a rune
+func chop(s string, n int) []string {
+	var out []string
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n
+		}
+		out = append(out, s[:i])
+		s = s[i:]
+	}
+	return append(out, s)
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
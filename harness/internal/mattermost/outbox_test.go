@@ -0,0 +1,234 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "fits",
+			text:  "hello\nworld",
+			limit: 20,
+			want:  []string{"hello\nworld"},
+		},
+		{
+			name:  "at line boundaries",
+			text:  "line one\nline two\nline three",
+			limit: 20,
+			want:  []string{"line one", "line two", "line three"},
+		},
+		{
+			name:  "long line without splitting runes",
+			text:  strings.Repeat("é", 10),
+			limit: 12,
+			want:  []string{"éééé", "éééé", "éé"},
+		},
+		{
+			name:  "code block reopened with its language",
+			text:  "```go\nx := 1\ny := 2\n```\nafter",
+			limit: 20,
+			want:  []string{"```go\nx := 1\n```", "```go\ny := 2\n```", "after"},
+		},
+		{
+			name:  "text after a closed block is not fenced",
+			text:  "```\ncode\n```\nplain text here\nmore plain text",
+			limit: 20,
+			want:  []string{"```\ncode\n```", "plain text here", "more plain text"},
+		},
+	}
+	for _, tt := range tests {
+		got := splitMessage(tt.text, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitMessage(%q, %d) = %q, want %q", tt.name, tt.text, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMessageLimits(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 40; i++ {
+		if i%7 == 0 {
+			b.WriteString("```python\n")
+		}
+		b.WriteString(strings.Repeat("日本語 text ", i%5+1) + "\n")
+		if i%7 == 3 {
+			b.WriteString("```\n")
+		}
+	}
+	text := b.String()
+	for _, limit := range []int{60, 100, 250} {
+		for i, part := range splitMessage(text, limit) {
+			if len(part) > limit {
+				t.Errorf("limit %d: part %d is %d bytes", limit, i, len(part))
+			}
+			if !utf8.ValidString(part) {
+				t.Errorf("limit %d: part %d splits a rune: %q", limit, i, part)
+			}
+			if fences := strings.Count(part, "```"); fences%2 != 0 {
+				t.Errorf("limit %d: part %d leaves a code block open: %q", limit, i, part)
+			}
+		}
+	}
+}
+
+func TestChop(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want []string
+	}{
+		{"", 4, []string{""}},
+		{"abc", 4, []string{"abc"}},
+		{"abcdefgh", 4, []string{"abcd", "efgh"}},
+		{"abcdefghi", 4, []string{"abcd", "efgh", "i"}},
+		{"héllo", 2, []string{"h", "é", "ll", "o"}},
+		{"日本語", 4, []string{"日", "本", "語"}},
+	}
+	for _, tt := range tests {
+		if got := chop(tt.s, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("chop(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		lo     time.Duration
+		hi     time.Duration
+	}{
+		{"", 0, 0},
+		{"5", 5 * time.Second, 5 * time.Second},
+		{" 120 ", 2 * time.Minute, 2 * time.Minute},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header); got < tt.lo || got > tt.hi {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.header, got, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestSendDueOrdering(t *testing.T) {
+	// queued is a post in the outbox; posts fail when their text is in fail
+	type queued struct {
+		root, channel, text string
+		later               bool // not due yet
+	}
+	tests := []struct {
+		name  string
+		queue []queued
+		fail  []string
+		want  []string // texts posted, in order
+	}{
+		{
+			name: "in order",
+			queue: []queued{
+				{root: "t1", channel: "c1", text: "a1"},
+				{root: "t1", channel: "c1", text: "a2"},
+			},
+			want: []string{"a1", "a2"},
+		},
+		{
+			name: "failure holds back its thread only",
+			queue: []queued{
+				{root: "t1", channel: "c1", text: "a1"},
+				{root: "t2", channel: "c1", text: "b1"},
+				{root: "t1", channel: "c1", text: "a2"},
+				{root: "t2", channel: "c1", text: "b2"},
+			},
+			fail: []string{"a1"},
+			want: []string{"b1", "b2"},
+		},
+		{
+			name: "post waiting to retry holds back its thread",
+			queue: []queued{
+				{root: "t1", channel: "c1", text: "a1", later: true},
+				{root: "t1", channel: "c1", text: "a2"},
+				{root: "t2", channel: "c1", text: "b1"},
+			},
+			want: []string{"b1"},
+		},
+		{
+			name: "top-level posts are ordered by channel",
+			queue: []queued{
+				{channel: "c1", text: "x1", later: true},
+				{channel: "c1", text: "x2"},
+				{channel: "c2", text: "y1"},
+				{root: "t1", channel: "c1", text: "a1"},
+			},
+			want: []string{"y1", "a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			var mu sync.Mutex
+			var posted []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Message string `json:"message"`
+				}
+				json.NewDecoder(r.Body).Decode(&body)
+				for _, f := range tt.fail {
+					if body.Message == f {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+				}
+				mu.Lock()
+				posted = append(posted, body.Message)
+				mu.Unlock()
+				json.NewEncoder(w).Encode(map[string]string{"id": "post-" + body.Message})
+			}))
+			defer srv.Close()
+
+			appState := state.New(&state.Config{})
+			b, err := NewBridge("ws://unused", srv.URL, "token", appState, log.New(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			for i, q := range tt.queue {
+				o := &state.Outbound{
+					ID:          q.text,
+					ChannelID:   q.channel,
+					RootID:      q.root,
+					Parts:       []string{q.text},
+					Status:      state.OutboundPending,
+					NextAttempt: now,
+					CreatedAt:   now.Add(time.Duration(i) * time.Millisecond),
+				}
+				if q.later {
+					o.NextAttempt = now.Add(time.Hour)
+				}
+				appState.Enqueue(o)
+			}
+
+			b.sendDue()
+			if !reflect.DeepEqual(posted, tt.want) {
+				t.Errorf("posted %q, want %q", posted, tt.want)
+			}
+		})
+	}
+}
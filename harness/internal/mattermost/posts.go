@@ -0,0 +1,81 @@
+package mattermost
+
+import "fmt"
+
+// Post is an outbound Mattermost post
+type Post struct {
+	ChannelID   string
+	RootID      string
+	Message     string
+	Attachments []Attachment
+	FileIDs     []string // uploaded with UploadFile to the same channel
+	// Files are uploaded by the outbox before the post is sent. If they
+	// cannot be, Fallback is posted instead.
+	Files    []File
+	Fallback string
+}
+
+// File is an attachment for the outbox to upload
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Attachment is a Mattermost message attachment, optionally carrying
+// interactive buttons
+type Attachment struct {
+	Fallback string   `json:"fallback,omitempty"`
+	Color    string   `json:"color,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Actions  []Action `json:"actions,omitempty"`
+}
+
+// Action is a button that Mattermost posts back to an integration URL
+type Action struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Style       string      `json:"style,omitempty"` // default | primary | danger | good
+	Integration Integration `json:"integration"`
+}
+
+// Integration is where a button click is sent, with the context echoed back
+type Integration struct {
+	URL     string                 `json:"url"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+// ActionRequest is what Mattermost posts to an integration URL on a click
+type ActionRequest struct {
+	UserID    string                 `json:"user_id"`
+	UserName  string                 `json:"user_name"` // unverified; resolve UserID instead
+	ChannelID string                 `json:"channel_id"`
+	PostID    string                 `json:"post_id"`
+	Context   map[string]interface{} `json:"context"`
+}
+
+// ActionResponse answers a click: Update replaces the clicked post and
+// EphemeralText is shown only to the clicker
+type ActionResponse struct {
+	Update        *PostUpdate `json:"update,omitempty"`
+	EphemeralText string      `json:"ephemeral_text,omitempty"`
+}
+
+// PostUpdate is the new content of an updated post
+type PostUpdate struct {
+	Message string                 `json:"message"`
+	Props   map[string]interface{} `json:"props"`
+}
+
+// UpdatePost replaces a post's message and attachments, e.g. to swap
+// approval buttons for the decision
+func (b *Bridge) UpdatePost(postID, message string, attachments []Attachment) error {
+	if postID == "" {
+		return fmt.Errorf("no post to update")
+	}
+	body := map[string]interface{}{
+		"message": message,
+		"props":   map[string]interface{}{"attachments": attachments},
+	}
+	return b.call("PUT", "/api/v4/posts/"+postID+"/patch", body, nil)
+}
@@ -0,0 +1,181 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Channel types in posted events
+const channelDirect = "D"
+
+// identity is who the bot is and where it listens, resolved at connect
+type identity struct {
+	userID    string
+	username  string
+	channelID string // configured channel; empty when none is configured
+}
+
+// identify looks up the bot's own user and the configured channel's ID
+func (b *Bridge) identify() (identity, error) {
+	var me struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := b.get("/api/v4/users/me", &me); err != nil {
+		return identity{}, fmt.Errorf("bot user: %w", err)
+	}
+	id := identity{userID: me.ID, username: me.Username}
+
+	cfg := b.state.Config().Mattermost
+	if cfg.Channel == "" {
+		return id, nil
+	}
+	channelID, err := b.findChannel(cfg.Team, cfg.Channel)
+	if err != nil {
+		return identity{}, err
+	}
+	id.channelID = channelID
+	return id, nil
+}
+
+// findChannel resolves a channel name in the named team, or in the bot's
+// teams when no team is configured
+func (b *Bridge) findChannel(team, name string) (string, error) {
+	var channel struct {
+		ID string `json:"id"`
+	}
+	if team != "" {
+		path := fmt.Sprintf("/api/v4/teams/name/%s/channels/name/%s", url.PathEscape(team), url.PathEscape(name))
+		if err := b.get(path, &channel); err != nil {
+			return "", fmt.Errorf("channel %s in team %s: %w", name, team, err)
+		}
+		return channel.ID, nil
+	}
+
+	var teams []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := b.get("/api/v4/users/me/teams", &teams); err != nil {
+		return "", fmt.Errorf("bot teams: %w", err)
+	}
+	for _, t := range teams {
+		path := fmt.Sprintf("/api/v4/teams/%s/channels/name/%s", t.ID, url.PathEscape(name))
+		if b.get(path, &channel) == nil {
+			return channel.ID, nil
+		}
+	}
+	return "", fmt.Errorf("channel %s not found in any of the bot's %d teams", name, len(teams))
+}
+
+// forward decides whether a post reaches the harness: never the bot's own
+// posts; otherwise posts in the configured channel, direct messages to the
+// bot and mentions of it anywhere else
+func (b *Bridge) forward(userID, channelID, channelType, message string, mentions []string) bool {
+	b.mu.Lock()
+	id := b.self
+	b.mu.Unlock()
+
+	if userID == id.userID {
+		return false
+	}
+	if id.channelID == "" || channelID == id.channelID {
+		return true
+	}
+	if channelType == channelDirect {
+		return true
+	}
+	for _, m := range mentions {
+		if m == id.userID {
+			return true
+		}
+	}
+	return mentioned(message, id.username)
+}
+
+// mentioned reports whether a message @-mentions a username as a whole
+// word: "@agent" and "@agent." count, "@agent-ops", "@agent.bot" and
+// "ops@agent" do not
+func mentioned(message, username string) bool {
+	if username == "" {
+		return false
+	}
+	re := regexp.MustCompile(`(?i)(^|[^\w.@-])@` + regexp.QuoteMeta(username) + `\.?([^\w.-]|$)`)
+	return re.MatchString(message)
+}
+
+// get calls the Mattermost REST API and decodes the JSON response
+func (b *Bridge) get(path string, out interface{}) error {
+	return b.call("GET", path, nil, out)
+}
+
+// call sends a JSON request to the Mattermost REST API and decodes the
+// response into out when it is non-nil
+func (b *Bridge) call(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, b.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return b.do(req, out)
+}
+
+// do sends an authenticated request to the Mattermost REST API and decodes
+// the response into out when it is non-nil
+func (b *Bridge) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+b.botToken)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return &apiError{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Authorized reports whether a message's sender holds a role under the
+// access rules in workspace.yaml. Roles go to the username the directory
+// resolves for the sender's ID; deactivated accounts and senders that
+// cannot be resolved hold none.
+func (b *Bridge) Authorized(msg Message, role state.Role) bool {
+	u, err := b.users.User(msg.UserID)
+	if err != nil || !u.Active() {
+		return false
+	}
+	access := b.state.Config().Access
+	return access.Allows(role, u.Username, nil)
+}
+
+// Refuse logs an unauthorized attempt and answers it politely in thread
+func (b *Bridge) Refuse(msg Message, action string) {
+	b.logger.Warn("Unauthorized Mattermost request", "user", msg.Username, "user_id", msg.UserID, "channel", msg.ChannelID, "action", action)
+	who := msg.Username
+	if who == "" {
+		who = "there"
+	} else {
+		who = "@" + who
+	}
+	b.PostMessage(msg.ChannelID, msg.ThreadID, fmt.Sprintf("Sorry %s, you are not authorized to %s. Ask a workspace director to add you in workspace.yaml.", who, action))
+}
@@ -0,0 +1,60 @@
+package mattermost
+
+import "testing"
+
+func TestMentioned(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"@agent please look", true},
+		{"hey @agent", true},
+		{"hey @Agent, status?", true},
+		{"ping @agent.", true},
+		{"(@agent)", true},
+		{"@agent: spawn a worker", true},
+		{"line one\n@agent", true},
+
+		{"@agent-ops are on it", false},
+		{"@agent_bot", false},
+		{"@agents", false},
+		{"@agent.bot", false},
+		{"mail ops@agent", false},
+		{"agent", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := mentioned(tt.message, "agent"); got != tt.want {
+			t.Errorf("mentioned(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+	if mentioned("@agent", "") {
+		t.Error("empty username mentioned")
+	}
+}
+
+func TestForward(t *testing.T) {
+	b := &Bridge{self: identity{userID: "bot", username: "agent", channelID: "home"}}
+	tests := []struct {
+		name        string
+		user        string
+		channel     string
+		channelType string
+		message     string
+		mentions    []string
+		want        bool
+	}{
+		{"own post", "bot", "home", "O", "hi", nil, false},
+		{"configured channel", "u1", "home", "O", "hi", nil, true},
+		{"direct message", "u1", "dm", channelDirect, "hi", nil, true},
+		{"mention by ID", "u1", "other", "O", "hi", []string{"bot"}, true},
+		{"mention in text", "u1", "other", "O", "@agent hi", nil, true},
+		{"mention of a longer name", "u1", "other", "O", "@agent-ops hi", nil, false},
+		{"elsewhere", "u1", "other", "O", "hi", nil, false},
+	}
+	for _, tt := range tests {
+		if got := b.forward(tt.user, tt.channel, tt.channelType, tt.message, tt.mentions); got != tt.want {
+			t.Errorf("%s: forward = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mattermost
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userTTL is how long a directory entry is trusted before it is looked up
+// again, so renames and deactivations are picked up
+const userTTL = 15 * time.Minute
+
+// User is a Mattermost user as seen by the harness
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	DeleteAt  int64  `json:"delete_at"`
+}
+
+// DisplayName is the user's full name, nickname or username, in that
+// order of preference
+func (u *User) DisplayName() string {
+	if name := strings.TrimSpace(u.FirstName + " " + u.LastName); name != "" {
+		return name
+	}
+	if u.Nickname != "" {
+		return u.Nickname
+	}
+	return u.Username
+}
+
+// Active reports whether the account has not been deactivated
+func (u *User) Active() bool {
+	return u.DeleteAt == 0
+}
+
+type cachedUser struct {
+	user    *User
+	fetched time.Time
+}
+
+// Directory resolves Mattermost users by ID through the REST API, caching
+// answers for userTTL
+type Directory struct {
+	bridge *Bridge
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	byID map[string]cachedUser
+}
+
+func newDirectory(b *Bridge) *Directory {
+	return &Directory{
+		bridge: b,
+		ttl:    userTTL,
+		byID:   make(map[string]cachedUser),
+	}
+}
+
+// User returns the user with the given ID
+func (d *Directory) User(id string) (*User, error) {
+	if id == "" {
+		return nil, fmt.Errorf("no user ID")
+	}
+	if u := d.cached(id); u != nil {
+		return u, nil
+	}
+	var u User
+	if err := d.bridge.get("/api/v4/users/"+url.PathEscape(id), &u); err != nil {
+		return nil, fmt.Errorf("user %s: %w", id, err)
+	}
+	d.store(&u)
+	return &u, nil
+}
+
+func (d *Directory) cached(id string) *User {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.byID[id]
+	if !ok || time.Since(c.fetched) > d.ttl {
+		return nil
+	}
+	return c.user
+}
+
+func (d *Directory) store(u *User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.byID[u.ID] = cachedUser{user: u, fetched: time.Now()}
+}
@@ -0,0 +1,62 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+func TestAuthorized(t *testing.T) {
+	users := map[string]User{
+		"u-dir":  {ID: "u-dir", Username: "dana"},
+		"u-gone": {ID: "u-gone", Username: "gary", DeleteAt: 1767225600000},
+		"u-obs":  {ID: "u-obs", Username: "olive"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := users[strings.TrimPrefix(r.URL.Path, "/api/v4/users/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(u)
+	}))
+	defer srv.Close()
+
+	cfg := &state.Config{Access: state.Access{
+		Directors: state.Members{Users: []string{"dana", "gary"}},
+		Observers: state.Members{Users: []string{"olive"}},
+	}}
+	b, err := NewBridge("ws://mattermost.invalid", srv.URL, "token", state.New(cfg), log.New(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		msg  Message
+		role state.Role
+		want bool
+	}{
+		{"director", Message{UserID: "u-dir"}, state.RoleDirector, true},
+		{"director observes", Message{UserID: "u-dir"}, state.RoleObserver, true},
+		{"observer", Message{UserID: "u-obs"}, state.RoleObserver, true},
+		{"observer cannot direct", Message{UserID: "u-obs"}, state.RoleDirector, false},
+		{"deactivated director", Message{UserID: "u-gone"}, state.RoleObserver, false},
+		{"unknown user", Message{UserID: "u-none"}, state.RoleObserver, false},
+		{"no user ID", Message{Username: "dana"}, state.RoleObserver, false},
+		// The username is resolved from the ID, never taken from the message
+		{"claimed username", Message{UserID: "u-obs", Username: "dana"}, state.RoleDirector, false},
+	}
+	for _, tt := range tests {
+		if got := b.Authorized(tt.msg, tt.role); got != tt.want {
+			t.Errorf("%s: Authorized = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
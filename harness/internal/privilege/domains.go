@@ -0,0 +1,162 @@
+package privilege
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/proxy"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// allowPrefix marks a request for a domain on the task's egress allowlist
+// rather than a catalog privilege, e.g. "allow:proxy.golang.org"
+const allowPrefix = "allow:"
+
+// domainPattern accepts hostnames and "*.example.com" wildcards, with a
+// port when it is not 80 or 443
+var domainPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+(:[0-9]{1,5})?$`)
+
+// allowPattern matches a director allowing a domain directly in a task
+// thread: "allow proxy.golang.org for this task"
+var allowPattern = regexp.MustCompile(`(?i)^\s*allow\s+` + "`?" + `([A-Za-z0-9_.*:-]+)` + "`?" + `(?:\s+for\s+this\s+task)?\s*[.!]?\s*$`)
+
+// handleAllow extends the allowlist of the thread's active task at the
+// director's word, without waiting for a worker to ask
+func (s *Service) handleAllow(threadID, ref, by string) bool {
+	task := s.state.ActiveTaskForThread(threadID, "")
+	if task == nil {
+		return false
+	}
+	s.allowTask(task, ref, by)
+	return true
+}
+
+// allowTask adds a domain to a task's allowlist and reports whether it
+// was a domain that can be allowed
+func (s *Service) allowTask(task *state.Task, ref, by string) bool {
+	threadID := task.ThreadID
+	domain := strings.ToLower(ref)
+	if !domainPattern.MatchString(domain) {
+		s.mm.Notify(threadID, fmt.Sprintf("`%s` is not a domain I can allow.", ref))
+		return false
+	}
+	s.record(&state.Grant{
+		PrivilegeID: allowPrefix + domain,
+		Scope:       state.ScopePerTask,
+		Project:     task.Project,
+		TaskID:      task.ID,
+		ThreadID:    threadID,
+		GrantedBy:   by,
+		Reason:      "allowed in thread",
+	})
+	s.allowDomain(task.ID, domain)
+	s.mm.Notify(threadID, fmt.Sprintf("Workers on task `%s` may now reach `%s` through the egress proxy (allowed by %s).", task.ID, domain, by))
+	for _, w := range s.state.WorkersForTask(task.ID) {
+		if w.IsActive() {
+			s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s%s: allowed by %s", allowPrefix, domain, by))
+		}
+	}
+	return true
+}
+
+// requestDomain asks the director to add a domain to a task's allowlist
+func (s *Service) requestDomain(w *state.Worker, domain, justification string) error {
+	domain = strings.ToLower(domain)
+	subject := allowPrefix + domain
+	if !domainPattern.MatchString(domain) {
+		err := fmt.Errorf("%q is not a valid domain", domain)
+		s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_DENIED] %s: %v", subject, err))
+		return err
+	}
+	if s.domainAllowed(w.TaskID, domain) {
+		s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: already allowed", subject))
+		return nil
+	}
+	for _, a := range s.state.PendingApprovals("") {
+		if a.Kind == KindPrivilege && a.TaskID == w.TaskID && a.Subject == subject {
+			return nil // already waiting on the director
+		}
+	}
+
+	a := &state.Approval{
+		Kind:     KindPrivilege,
+		ThreadID: w.ThreadID,
+		TaskID:   w.TaskID,
+		WorkerID: w.ID,
+		Subject:  subject,
+		Detail:   justification,
+	}
+	prompt := fmt.Sprintf("**Egress request** from worker `%s` (project `%s`): allow `%s` for task `%s`\n> %s",
+		w.ID, w.Project, domain, w.TaskID, justification)
+	decided, note := s.applyPolicy(w, a, prompt)
+	if decided {
+		return nil
+	}
+	if err := s.approvals.Request(a, prompt+note); err != nil {
+		s.logger.Error("Failed to post egress request", "worker", w.ID, "error", err)
+	}
+	s.tellManager(fmt.Sprintf("[Harness] Worker %s asked to reach %s; awaiting director approval (request %s).", w.ID, domain, a.ID))
+	return nil
+}
+
+// decideDomain applies the director's answer to an egress domain request
+func (s *Service) decideDomain(a *state.Approval, domain string) {
+	if a.Status == state.ApprovalApproved {
+		s.approvalGrant(a, a.Subject, state.ScopePerTask)
+		s.allowDomain(a.TaskID, domain)
+		s.tellWorker(a.WorkerID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: approved by %s; reach it through the proxy in $HTTPS_PROXY", a.Subject, a.DecidedBy))
+	} else {
+		s.tellWorker(a.WorkerID, fmt.Sprintf("[PRIVILEGE_DENIED] %s: denied by %s; continue without it", a.Subject, a.DecidedBy))
+	}
+	s.mm.Notify(a.ThreadID, fmt.Sprintf("Egress to `%s` for task `%s` %s by %s.", domain, a.TaskID, a.Status, a.DecidedBy))
+	s.tellManager(fmt.Sprintf("[Harness] Egress to %s for task %s was %s by %s (request %s).", domain, a.TaskID, a.Status, a.DecidedBy, a.ID))
+}
+
+func (s *Service) allowDomain(taskID, domain string) {
+	s.state.UpdateTask(taskID, func(t *state.Task) {
+		for _, d := range t.AllowedDomains {
+			if d == domain {
+				return
+			}
+		}
+		t.AllowedDomains = append(t.AllowedDomains, domain)
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist egress allowlist", "error", err)
+	}
+}
+
+// disallowDomain removes a revoked domain unless another active grant on
+// the task still allows it
+func (s *Service) disallowDomain(taskID, domain string) {
+	t := s.state.GetTask(taskID)
+	if t == nil {
+		return
+	}
+	for _, g := range s.state.ActiveGrants(t.Project, taskID) {
+		if g.PrivilegeID == allowPrefix+domain {
+			return
+		}
+	}
+	s.state.UpdateTask(taskID, func(t *state.Task) {
+		kept := t.AllowedDomains[:0]
+		for _, d := range t.AllowedDomains {
+			if d != domain {
+				kept = append(kept, d)
+			}
+		}
+		t.AllowedDomains = kept
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist egress allowlist", "error", err)
+	}
+}
+
+func (s *Service) domainAllowed(taskID, domain string) bool {
+	if proxy.Match(s.state.Config().Egress.Allow, domain) {
+		return true
+	}
+	t := s.state.GetTask(taskID)
+	return t != nil && proxy.Match(t.AllowedDomains, domain)
+}
@@ -0,0 +1,261 @@
+package privilege
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// revokePattern matches a director revoking access in a task thread by
+// grant ID, privilege ID or allowed domain: "revoke github-token"
+var revokePattern = regexp.MustCompile(`(?i)^\s*revoke\s+` + "`?" + `([A-Za-z0-9_.*:-]+)` + "`?" + `\s*[.!]?\s*$`)
+
+// record adds a grant to the ledger
+func (s *Service) record(g *state.Grant) *state.Grant {
+	g.ID = newID()
+	g.GrantedAt = time.Now()
+	g.Status = state.GrantActive
+	if g.Scope == state.ScopePermanent {
+		g.TaskID = "" // covers the whole project
+	}
+	s.state.AddGrant(g)
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist privilege grant", "error", err)
+	}
+	s.logger.Info("Privilege granted", "grant", g.ID, "privilege", g.PrivilegeID, "scope", g.Scope, "project", g.Project, "task", g.TaskID, "by", g.GrantedBy)
+	return g
+}
+
+// approvalGrant starts a ledger entry for an approved request
+func (s *Service) approvalGrant(a *state.Approval, privilegeID, scope string) *state.Grant {
+	g := &state.Grant{
+		PrivilegeID: privilegeID,
+		Scope:       scope,
+		TaskID:      a.TaskID,
+		ThreadID:    a.ThreadID,
+		WorkerID:    a.WorkerID,
+		ApprovalID:  a.ID,
+		GrantedBy:   a.DecidedBy,
+		Reason:      a.Detail,
+	}
+	if a.Policy != "" {
+		g.GrantedBy = fmt.Sprintf("%s (%s)", policyDecider, a.Policy)
+	}
+	if t := s.state.GetTask(a.TaskID); t != nil {
+		g.Project = t.Project
+	} else if w := s.state.GetWorker(a.WorkerID); w != nil {
+		g.Project = w.Project
+	}
+	return s.record(g)
+}
+
+// GrantTask grants a catalog privilege to an active task without waiting
+// for a worker to ask, e.g. from a slash command. "allow:<domain>" adds the
+// domain to the task's egress allowlist.
+func (s *Service) GrantTask(taskID, privilegeID, by string) (*state.Grant, error) {
+	task := s.state.GetTask(taskID)
+	if task == nil {
+		return nil, fmt.Errorf("unknown task %q", taskID)
+	}
+	if task.Status != state.TaskActive {
+		return nil, fmt.Errorf("task %s is %s", task.ID, task.Status)
+	}
+	threadID := task.ThreadID
+	if domain, ok := strings.CutPrefix(privilegeID, allowPrefix); ok {
+		if !s.allowTask(task, domain, by) {
+			return nil, fmt.Errorf("%q is not a domain I can allow", domain)
+		}
+		for _, g := range s.state.ActiveGrants(task.Project, task.ID) {
+			if g.PrivilegeID == allowPrefix+strings.ToLower(domain) {
+				return g, nil
+			}
+		}
+		return nil, fmt.Errorf("could not allow %s", domain)
+	}
+
+	priv := s.state.Config().FindPrivilege(privilegeID)
+	if priv == nil {
+		return nil, fmt.Errorf("privilege %q is not in the catalog (available: %s)", privilegeID, strings.Join(catalogIDs(s.state.Config()), ", "))
+	}
+	for _, g := range s.state.ActiveGrants(task.Project, task.ID) {
+		if g.PrivilegeID == priv.ID {
+			return nil, fmt.Errorf("%s is already granted (grant %s)", priv.ID, g.ID)
+		}
+	}
+	g := s.record(&state.Grant{
+		PrivilegeID: priv.ID,
+		Scope:       grantScope(priv),
+		Project:     task.Project,
+		TaskID:      task.ID,
+		ThreadID:    threadID,
+		GrantedBy:   by,
+		Reason:      "granted by command",
+	})
+	s.realize(g, priv, by)
+	s.mm.Notify(threadID, fmt.Sprintf("Privilege **%s** granted to task `%s` by %s (%s grant `%s`; reply `revoke %s` to withdraw it).",
+		priv.ID, task.ID, by, g.Scope, g.ID, g.ID))
+	s.tellManager(fmt.Sprintf("[Harness] Privilege %s was granted to task %s by %s (grant %s).", priv.ID, task.ID, by, g.ID))
+	return g, nil
+}
+
+// covered returns the active workers a grant applies to
+func (s *Service) covered(g *state.Grant) []*state.Worker {
+	var workers []*state.Worker
+	for _, w := range s.state.ListWorkers() {
+		if !w.IsActive() || w.Project != g.Project {
+			continue
+		}
+		if g.Scope != state.ScopePermanent && w.TaskID != g.TaskID {
+			continue
+		}
+		workers = append(workers, w)
+	}
+	return workers
+}
+
+// syncWorkers refreshes the granted privilege list shown on workers
+func (s *Service) syncWorkers(workers []*state.Worker) {
+	for _, w := range workers {
+		granted := s.state.GrantedPrivileges(w.Project, w.TaskID)
+		s.state.UpdateWorker(w.ID, func(sw *state.Worker) {
+			sw.Privileges = granted
+		})
+	}
+}
+
+// Revoke ends an active grant and tears down the access it realized:
+// allowed domains leave the task's allowlist, networks are detached and
+// containers holding tokens or mounts are recreated without them
+func (s *Service) Revoke(id, by string) error {
+	var g state.Grant
+	ok := s.state.UpdateGrant(id, func(sg *state.Grant) {
+		g = *sg
+		if sg.Status != state.GrantActive {
+			return
+		}
+		sg.Status = state.GrantRevoked
+		sg.RevokedBy = by
+		sg.EndedAt = time.Now()
+	})
+	if !ok {
+		return fmt.Errorf("unknown grant %s", id)
+	}
+	if g.Status != state.GrantActive {
+		return fmt.Errorf("grant %s is already %s", id, g.Status)
+	}
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist privilege revocation", "error", err)
+	}
+	s.logger.Info("Privilege revoked", "grant", id, "privilege", g.PrivilegeID, "by", by)
+
+	workers := s.covered(&g)
+	s.syncWorkers(workers)
+
+	if domain, ok := strings.CutPrefix(g.PrivilegeID, allowPrefix); ok {
+		s.disallowDomain(g.TaskID, domain)
+	} else if priv := s.state.Config().FindPrivilege(g.PrivilegeID); priv != nil {
+		for _, w := range workers {
+			if granted(s.state.GrantedPrivileges(w.Project, w.TaskID), priv.ID) {
+				continue // still held through another grant
+			}
+			if priv.Network != "" {
+				if err := s.spawner.DetachEgress(w.ID); err != nil {
+					s.logger.Error("Failed to detach worker from egress", "worker", w.ID, "error", err)
+				}
+				// Networks from other grants stay attached
+				if err := s.spawner.AttachEgress(w.ID); err != nil {
+					s.logger.Error("Failed to reattach remaining egress", "worker", w.ID, "error", err)
+				}
+			}
+			if needsRecreate(priv) && (w.Status == state.WorkerRunning || w.Status == state.WorkerStuck) {
+				reason := fmt.Sprintf("was restarted to withdraw the %s privilege", priv.ID)
+				if _, err := s.spawner.Recreate(context.Background(), w.ID, spawner.HandoffPrompt(w, reason)); err != nil {
+					s.logger.Error("Failed to recreate worker without privilege", "worker", w.ID, "error", err)
+				}
+			}
+		}
+	}
+
+	for _, w := range workers {
+		s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_REVOKED] %s: revoked by %s", g.PrivilegeID, by))
+	}
+	s.mm.Notify(g.ThreadID, fmt.Sprintf("Grant `%s` (**%s**, %s) revoked by %s.", g.ID, g.PrivilegeID, g.Scope, by))
+	s.tellManager(fmt.Sprintf("[Harness] Privilege %s (grant %s) was revoked by %s.", g.PrivilegeID, g.ID, by))
+	return nil
+}
+
+// expireTask ends the per-task grants of a task that completed
+func (s *Service) expireTask(taskID string) {
+	now := time.Now()
+	var expired []string
+	for _, g := range s.state.ListGrants() {
+		if g.Status != state.GrantActive || g.Scope == state.ScopePermanent || g.TaskID != taskID {
+			continue
+		}
+		s.state.UpdateGrant(g.ID, func(sg *state.Grant) {
+			sg.Status = state.GrantExpired
+			sg.EndedAt = now
+		})
+		expired = append(expired, g.PrivilegeID)
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist grant expiry", "error", err)
+	}
+	s.logger.Info("Per-task grants expired", "task", taskID, "privileges", strings.Join(expired, ","))
+}
+
+// handleRevoke answers "revoke <grant|privilege|domain>" in a task thread
+func (s *Service) handleRevoke(threadID, ref, by string) {
+	g := s.resolveGrant(threadID, ref)
+	if g == nil {
+		s.mm.Notify(threadID, fmt.Sprintf("No active grant `%s` for this thread.", ref))
+		return
+	}
+	if err := s.Revoke(g.ID, by); err != nil {
+		s.mm.Notify(threadID, err.Error())
+	}
+}
+
+// resolveGrant finds an active grant by ID, or by privilege or domain among
+// the grants covering the thread's active task
+func (s *Service) resolveGrant(threadID, ref string) *state.Grant {
+	ref = strings.ToLower(ref)
+	if g := s.state.GetGrant(ref); g != nil && g.Status == state.GrantActive {
+		return g
+	}
+	task := s.state.ActiveTaskForThread(threadID, "")
+	if task == nil {
+		return nil
+	}
+	for _, g := range s.state.ActiveGrants(task.Project, task.ID) {
+		if g.PrivilegeID == ref || g.PrivilegeID == allowPrefix+ref {
+			return g
+		}
+	}
+	return nil
+}
+
+func granted(ids []string, id string) bool {
+	for _, g := range ids {
+		if g == id {
+			return true
+		}
+	}
+	return false
+}
+
+func newID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,39 @@
+package privilege
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// policyDecider is recorded as DecidedBy on approvals a policy rule decided
+const policyDecider = "policy"
+
+// applyPolicy decides a request by the first matching workspace.yaml policy
+// rule. It returns false when the director must be asked, with a note to
+// add to the prompt if a rule escalated explicitly.
+func (s *Service) applyPolicy(w *state.Worker, a *state.Approval, summary string) (bool, string) {
+	rule, label := s.state.Config().MatchPolicy(w.Project, w.WorkerType, a.Subject, time.Now())
+	if rule == nil {
+		return false, ""
+	}
+	reason := ""
+	if rule.Reason != "" {
+		reason = ": " + rule.Reason
+	}
+	if rule.Action == state.PolicyEscalate {
+		s.logger.Info("Privilege request escalated by policy", "worker", w.ID, "privilege", a.Subject, "policy", label)
+		return false, fmt.Sprintf("\n_Escalated by policy **%s**%s._", label, reason)
+	}
+
+	approved := rule.Action == state.PolicyApprove
+	verb := "auto-denied"
+	if approved {
+		verb = "auto-approved"
+	}
+	a.Policy = label
+	s.logger.Info("Privilege request decided by policy", "worker", w.ID, "privilege", a.Subject, "policy", label, "action", rule.Action)
+	s.approvals.Resolve(a, approved, policyDecider, fmt.Sprintf("%s\n\n_%s by policy **%s**%s._", summary, verb, label, reason))
+	return true, ""
+}
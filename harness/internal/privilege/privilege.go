@@ -0,0 +1,267 @@
+package privilege
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+// KindPrivilege is the approval kind for worker privilege requests
+const KindPrivilege = "privilege"
+
+// requestPattern matches "[PRIVILEGE_REQUEST] I need <id>: <justification>"
+// as written by workers (see rules/sandbox.md). "[PRIVILEGE_REQUEST:<worker-id>] ..."
+// names the worker explicitly; the manager uses its request-privilege
+// directive instead.
+// "allow:<domain>" asks for a domain on the task's egress allowlist.
+var requestPattern = regexp.MustCompile(`\[PRIVILEGE_REQUEST(?::([^\]\s]+))?\]\s*(?:I need\s+)?((?:allow:)?[A-Za-z0-9_.*-]+(?::[0-9]+)?)\s*:\s*(.*)$`)
+
+// Request is a parsed privilege request
+type Request struct {
+	WorkerID      string
+	PrivilegeID   string
+	Justification string
+}
+
+// ParseRequest extracts a privilege request from a line of output
+func ParseRequest(line string) (Request, bool) {
+	m := requestPattern.FindStringSubmatch(line)
+	if m == nil {
+		return Request{}, false
+	}
+	return Request{
+		WorkerID:      m[1],
+		PrivilegeID:   m[2],
+		Justification: strings.TrimSpace(m[3]),
+	}, true
+}
+
+// Service runs the privilege request loop: validate against the catalog in
+// workspace.yaml, ask the director in the task thread, record the decision
+// and tell the worker and the manager
+type Service struct {
+	state     *state.AppState
+	spawner   *spawner.Spawner
+	approvals *approval.Broker
+	mm        *mattermost.Bridge
+	logger    *log.Logger
+
+	mu            sync.RWMutex
+	ctx           context.Context
+	notifyManager func(string) error
+}
+
+// New creates the privilege service and hooks it into worker output and
+// privilege approvals
+func New(appState *state.AppState, sp *spawner.Spawner, approvals *approval.Broker, mm *mattermost.Bridge, logger *log.Logger) *Service {
+	s := &Service{
+		state:     appState,
+		spawner:   sp,
+		approvals: approvals,
+		mm:        mm,
+		logger:    logger,
+		ctx:       context.Background(),
+	}
+	sp.OnOutput(s.HandleWorkerOutput)
+	sp.OnTaskEnd(s.expireTask)
+	approvals.Register(KindPrivilege, s.decide)
+	return s
+}
+
+// SetManagerNotifier sets how outcomes are reported to the manager agent
+func (s *Service) SetManagerNotifier(fn func(string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifyManager = fn
+}
+
+// SetContext sets the harness lifetime context. Pending restarts that apply
+// a grant are abandoned when it is cancelled.
+func (s *Service) SetContext(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+}
+
+func (s *Service) runContext() context.Context {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ctx
+}
+
+// HandleMessage handles director commands in task threads: allowing a
+// domain for the task or revoking a grant. Returns true when the message
+// was consumed and should not be forwarded.
+func (s *Service) HandleMessage(msg mattermost.Message) bool {
+	if msg.ThreadID == "" {
+		return false
+	}
+	by := msg.Username
+	if by == "" {
+		by = msg.UserID
+	}
+	revoke := revokePattern.FindStringSubmatch(msg.Text)
+	allow := allowPattern.FindStringSubmatch(msg.Text)
+	if revoke == nil && allow == nil {
+		return false
+	}
+	if !s.mm.Authorized(msg, state.RoleDirector) {
+		s.mm.Refuse(msg, "change privileges")
+		return true
+	}
+	if revoke != nil {
+		s.handleRevoke(msg.ThreadID, revoke[1], by)
+		return true
+	}
+	return s.handleAllow(msg.ThreadID, allow[1], by)
+}
+
+// HandleWorkerOutput picks privilege requests out of a worker's output
+func (s *Service) HandleWorkerOutput(workerID, line string, ev *stream.Event) {
+	texts := []string{line}
+	if ev != nil {
+		texts = ev.Text()
+	}
+	for _, text := range texts {
+		req, ok := ParseRequest(text)
+		if !ok {
+			continue
+		}
+		req.WorkerID = workerID
+		go func() {
+			if err := s.Request(req); err != nil {
+				s.logger.Warn("Privilege request rejected", "worker", workerID, "privilege", req.PrivilegeID, "error", err)
+			}
+		}()
+	}
+}
+
+// Request validates a privilege request and asks the director to decide.
+// Invalid requests are answered immediately and returned as errors.
+func (s *Service) Request(req Request) error {
+	w := s.state.GetWorker(req.WorkerID)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", req.WorkerID)
+	}
+	s.logger.Info("Privilege requested", "worker", w.ID, "privilege", req.PrivilegeID, "justification", req.Justification)
+
+	if domain, ok := strings.CutPrefix(req.PrivilegeID, allowPrefix); ok {
+		return s.requestDomain(w, domain, req.Justification)
+	}
+
+	priv := s.state.Config().FindPrivilege(req.PrivilegeID)
+	if priv == nil {
+		err := fmt.Errorf("privilege %q is not in the catalog (available: %s)", req.PrivilegeID, strings.Join(catalogIDs(s.state.Config()), ", "))
+		s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_DENIED] %s: %v", req.PrivilegeID, err))
+		return err
+	}
+	if granted(s.state.GrantedPrivileges(w.Project, w.TaskID), priv.ID) {
+		s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: already granted", priv.ID))
+		return nil
+	}
+	for _, a := range s.state.PendingApprovals("") {
+		if a.Kind == KindPrivilege && a.WorkerID == w.ID && a.Subject == priv.ID {
+			return nil // already waiting on the director
+		}
+	}
+
+	a := &state.Approval{
+		Kind:     KindPrivilege,
+		ThreadID: w.ThreadID,
+		TaskID:   w.TaskID,
+		WorkerID: w.ID,
+		Subject:  priv.ID,
+		Detail:   req.Justification,
+	}
+	prompt := fmt.Sprintf("**Privilege request** from worker `%s` (project `%s`): **%s** — %s (%s grant)\n> %s",
+		w.ID, w.Project, priv.ID, priv.Description, grantScope(priv), req.Justification)
+	decided, note := s.applyPolicy(w, a, prompt)
+	if decided {
+		return nil
+	}
+	if err := s.approvals.Request(a, prompt+note); err != nil {
+		s.logger.Error("Failed to post privilege request", "worker", w.ID, "error", err)
+	}
+	s.tellManager(fmt.Sprintf("[Harness] Worker %s requested privilege %s; awaiting director approval (request %s).", w.ID, priv.ID, a.ID))
+	return nil
+}
+
+// decide applies the director's answer to a privilege request
+func (s *Service) decide(a *state.Approval) {
+	if domain, ok := strings.CutPrefix(a.Subject, allowPrefix); ok {
+		s.decideDomain(a, domain)
+		return
+	}
+	var note string
+	priv := s.state.Config().FindPrivilege(a.Subject)
+	if a.Status == state.ApprovalApproved && priv != nil {
+		g := s.approvalGrant(a, priv.ID, grantScope(priv))
+		note = fmt.Sprintf(" (%s grant `%s`; reply `revoke %s` to withdraw it)", g.Scope, g.ID, g.ID)
+		s.realize(g, priv, a.DecidedBy)
+	} else {
+		s.tellWorker(a.WorkerID, fmt.Sprintf("[PRIVILEGE_DENIED] %s: denied by %s; continue without it", a.Subject, a.DecidedBy))
+	}
+
+	s.mm.Notify(a.ThreadID, fmt.Sprintf("Privilege **%s** for worker `%s` %s by %s%s.", a.Subject, a.WorkerID, a.Status, a.DecidedBy, note))
+	s.tellManager(fmt.Sprintf("[Harness] Privilege %s for worker %s was %s by %s (request %s).", a.Subject, a.WorkerID, a.Status, a.DecidedBy, a.ID))
+}
+
+// realize applies a new grant to every worker it covers
+func (s *Service) realize(g *state.Grant, priv *state.Privilege, by string) {
+	workers := s.covered(g)
+	s.syncWorkers(workers)
+	for _, w := range workers {
+		switch {
+		case needsRecreate(priv):
+			s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: approved by %s. Your container will be recreated in %s to apply it (%s); update HANDOFF.md now.",
+				priv.ID, by, restartGrace, describe(priv)))
+			go s.recreate(s.runContext(), w.ID, priv)
+		case priv.Network != "":
+			go s.attach(w.ID, priv, by)
+		default:
+			s.tellWorker(w.ID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: approved by %s", priv.ID, by))
+		}
+	}
+}
+
+func (s *Service) tellWorker(workerID, text string) {
+	if err := s.spawner.Send(workerID, text); err != nil {
+		s.logger.Warn("Could not deliver privilege outcome to worker", "worker", workerID, "error", err)
+	}
+}
+
+func (s *Service) tellManager(text string) {
+	s.mu.RLock()
+	fn := s.notifyManager
+	s.mu.RUnlock()
+	if fn == nil {
+		return
+	}
+	if err := fn(text); err != nil {
+		s.logger.Warn("Could not notify manager", "error", err)
+	}
+}
+
+func catalogIDs(cfg *state.Config) []string {
+	ids := make([]string, len(cfg.Privileges))
+	for i, p := range cfg.Privileges {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func grantScope(p *state.Privilege) string {
+	if p.Grant == "" {
+		return "per-task"
+	}
+	return p.Grant
+}
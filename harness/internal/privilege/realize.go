@@ -0,0 +1,85 @@
+package privilege
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// restartGrace gives a worker time to update HANDOFF.md before it is
+// recreated to pick up a newly granted privilege
+const restartGrace = 60 * time.Second
+
+// needsRecreate reports whether realizing a privilege requires a new
+// container: Docker cannot add environment or bind mounts to a running one
+func needsRecreate(p *state.Privilege) bool {
+	return p.TokenEnv != "" || p.Mount != ""
+}
+
+// describe says how a privilege shows up inside the worker
+func describe(p *state.Privilege) string {
+	switch {
+	case p.TokenEnv != "" && p.Mount != "":
+		return fmt.Sprintf("$%s is set and %s is mounted %s", p.TokenEnv, spawner.PrivilegeMount(p.ID), mountMode(p))
+	case p.TokenEnv != "":
+		return fmt.Sprintf("$%s is set", p.TokenEnv)
+	case p.Mount != "":
+		return fmt.Sprintf("%s is mounted %s", spawner.PrivilegeMount(p.ID), mountMode(p))
+	default:
+		return "no container changes"
+	}
+}
+
+func mountMode(p *state.Privilege) string {
+	if p.Mode == "readonly" {
+		return "read-only"
+	}
+	return "read-write"
+}
+
+// recreate replaces a worker's container after the grace period so the
+// spawner applies its granted privileges. The replacement resumes from the
+// same workspace and HANDOFF.md. Shutting down during the grace period
+// leaves the worker as it is.
+func (s *Service) recreate(ctx context.Context, workerID string, p *state.Privilege) {
+	t := time.NewTimer(restartGrace)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-t.C:
+	}
+
+	w := s.state.GetWorker(workerID)
+	if w == nil || (w.Status != state.WorkerRunning && w.Status != state.WorkerStuck) {
+		// Finished, failed or paused workers pick the grant up on their next spawn
+		return
+	}
+	reason := fmt.Sprintf("was restarted to apply the %s privilege (%s)", p.ID, describe(p))
+	if _, err := s.spawner.Recreate(ctx, workerID, spawner.HandoffPrompt(w, reason)); err != nil {
+		s.logger.Error("Failed to recreate worker with privilege", "worker", workerID, "privilege", p.ID, "error", err)
+		s.mm.Notify(w.ThreadID, fmt.Sprintf("Could not apply privilege **%s** to worker `%s`: %v", p.ID, workerID, err))
+		return
+	}
+	s.logger.Info("Privilege applied", "worker", workerID, "privilege", p.ID)
+	s.mm.Notify(w.ThreadID, fmt.Sprintf("Worker `%s` recreated with privilege **%s** (%s).", workerID, p.ID, describe(p)))
+}
+
+// attach connects a running worker to a privilege's network in place
+func (s *Service) attach(workerID string, p *state.Privilege, grantedBy string) {
+	w := s.state.GetWorker(workerID)
+	if w == nil || !w.IsActive() {
+		// Later spawns of the worker are attached when they start
+		return
+	}
+	if err := s.spawner.AttachEgress(workerID); err != nil {
+		s.logger.Error("Failed to attach worker to egress network", "worker", workerID, "privilege", p.ID, "error", err)
+		s.mm.Notify(w.ThreadID, fmt.Sprintf("Could not apply privilege **%s** to worker `%s`: %v", p.ID, workerID, err))
+		s.tellWorker(workerID, fmt.Sprintf("[PRIVILEGE_DENIED] %s: granted, but attaching the network failed: %v", p.ID, err))
+		return
+	}
+	s.tellWorker(workerID, fmt.Sprintf("[PRIVILEGE_GRANTED] %s: approved by %s; you are now attached to the %s network", p.ID, grantedBy, p.Network))
+}
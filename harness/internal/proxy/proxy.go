@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// dialTimeout bounds connecting to an upstream host
+const dialTimeout = 15 * time.Second
+
+// hopHeaders are connection-scoped and not forwarded upstream
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Proxy is an HTTP/HTTPS forward proxy for workers on the sandbox network.
+// It identifies the worker by its sandbox address and only lets it reach
+// domains allowed for its task.
+type Proxy struct {
+	port      int
+	state     *state.AppState
+	logger    *log.Logger
+	transport *http.Transport
+
+	mu         sync.Mutex
+	ips        map[string]string // sandbox IP -> worker ID
+	containers map[string]string // container ID -> sandbox IP, inspected once
+}
+
+// New creates an egress proxy listening on port
+func New(port int, appState *state.AppState, logger *log.Logger) *Proxy {
+	return &Proxy{
+		port:      port,
+		state:     appState,
+		logger:    logger,
+		transport: &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: dialTimeout}).DialContext},
+		ips:       make(map[string]string),
+
+		containers: make(map[string]string),
+	}
+}
+
+// Run serves the proxy until ctx is cancelled
+func (p *Proxy) Run(ctx context.Context) {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", p.port),
+		Handler: p,
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	p.logger.Info("Egress proxy starting", "port", p.port)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		p.logger.Error("Egress proxy error", "error", err)
+	}
+}
+
+// ServeHTTP handles CONNECT tunnels and plain HTTP forwarding
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if r.Method != http.MethodConnect && r.URL.Host != "" {
+		host = r.URL.Host
+	}
+	target := egressTarget(r.Method, host)
+
+	worker := p.worker(r.RemoteAddr)
+	entry := state.EgressRequest{
+		Time:   time.Now(),
+		Method: r.Method,
+		Host:   target,
+	}
+	if r.Method != http.MethodConnect {
+		entry.URL = r.URL.String()
+	}
+	if worker != nil {
+		entry.WorkerID = worker.ID
+		entry.TaskID = worker.TaskID
+		entry.Allowed = p.allowed(worker, target)
+	}
+	p.state.RecordEgress(entry)
+	p.logger.Info("Egress request", "worker", entry.WorkerID, "task", entry.TaskID, "method", r.Method, "host", target, "url", entry.URL, "allowed", entry.Allowed)
+
+	if worker == nil {
+		http.Error(w, "egress proxy: request did not come from a known worker", http.StatusForbidden)
+		return
+	}
+	if !entry.Allowed {
+		http.Error(w, fmt.Sprintf("egress proxy: %s is not allowed for task %s; request it with [PRIVILEGE_REQUEST] I need allow:%s: <why>", target, worker.TaskID, target), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, host)
+		return
+	}
+	p.forward(w, r)
+}
+
+// tunnel relays a CONNECT stream; TLS stays end to end between the worker
+// and the upstream host
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, host string) {
+	upstream, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "egress proxy: hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	go func() {
+		// Bytes the client sent after the CONNECT line are already buffered
+		if n := buf.Reader.Buffered(); n > 0 {
+			pending, _ := buf.Reader.Peek(n)
+			upstream.Write(pending)
+		}
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+// forward proxies a plain HTTP request
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// egressTarget names where a request goes as allowlists see it: the
+// hostname, or "host:port" for ports other than 80 and 443, which need an
+// allowlist entry that names them
+func egressTarget(method, host string) string {
+	hostname := strings.ToLower(host)
+	port := "443"
+	if method != http.MethodConnect {
+		port = "80"
+	}
+	if h, pt, err := net.SplitHostPort(hostname); err == nil {
+		hostname, port = h, pt
+	}
+	if port != "80" && port != "443" {
+		return net.JoinHostPort(hostname, port)
+	}
+	return hostname
+}
+
+// allowed reports whether a worker may reach a host, given as "host:port"
+// for ports other than 80 and 443: through the global allowlist, its task's
+// allowlist, or because it holds full egress
+func (p *Proxy) allowed(w *state.Worker, hostname string) bool {
+	if w.Egress == state.EgressAttached {
+		return true
+	}
+	if Match(p.state.Config().Egress.Allow, hostname) {
+		return true
+	}
+	if t := p.state.GetTask(w.TaskID); t != nil && t.Status == state.TaskActive {
+		return Match(t.AllowedDomains, hostname)
+	}
+	return false
+}
+
+// Match reports whether hostname is covered by an allowlist. "example.com"
+// matches only itself; "*.example.com" matches example.com and its
+// subdomains. A "host:port" hostname only matches entries naming the port.
+func Match(allow []string, hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	for _, pattern := range allow {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if base, ok := strings.CutPrefix(pattern, "*."); ok {
+			if hostname == base || strings.HasSuffix(hostname, "."+base) {
+				return true
+			}
+		} else if hostname == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// worker identifies the active worker behind a remote address by its
+// address on the sandbox network
+func (p *Proxy) worker(remoteAddr string) *state.Worker {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil
+	}
+	p.mu.Lock()
+	id, ok := p.ips[ip]
+	p.mu.Unlock()
+	if ok {
+		if w := p.state.GetWorker(id); w != nil && w.IsActive() {
+			return w
+		}
+	}
+
+	// Containers come and go; rebuild the address map on a miss. Only
+	// containers not seen before are inspected, so repeated misses from an
+	// unknown address cost nothing once every worker is known.
+	p.mu.Lock()
+	known := p.containers
+	p.mu.Unlock()
+	ips := make(map[string]string)
+	containers := make(map[string]string)
+	for _, w := range p.state.ListWorkers() {
+		if w.ContainerID == "" || !w.IsActive() {
+			continue
+		}
+		addr, ok := known[w.ContainerID]
+		if !ok {
+			var err error
+			if addr, err = sandboxIP(w.ContainerID); err != nil {
+				p.logger.Debug("Failed to inspect worker address", "worker", w.ID, "error", err)
+				continue
+			}
+		}
+		containers[w.ContainerID] = addr
+		ips[addr] = w.ID
+	}
+	p.mu.Lock()
+	p.ips = ips
+	p.containers = containers
+	p.mu.Unlock()
+
+	if id, ok := ips[ip]; ok {
+		return p.state.GetWorker(id)
+	}
+	return nil
+}
+
+func sandboxIP(containerID string) (string, error) {
+	return spawner.NetworkIP(containerID, spawner.SandboxNetwork)
+}
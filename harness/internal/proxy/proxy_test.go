@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	allow := []string{"github.com", "*.golang.org", "Registry.Example.com", "db.internal:5432"}
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"github.com", true},
+		{"GitHub.com", true},
+		{"github.com.", true},
+		{"api.github.com", false},
+		{"evilgithub.com", false},
+
+		{"golang.org", true},
+		{"proxy.golang.org", true},
+		{"a.b.golang.org", true},
+		{"notgolang.org", false},
+
+		{"registry.example.com", true},
+
+		{"db.internal:5432", true},
+		{"db.internal:5433", false},
+		{"db.internal", false},
+		{"github.com:8443", false},
+		{"proxy.golang.org:8080", false},
+	}
+	for _, tt := range tests {
+		if got := Match(allow, tt.hostname); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.hostname, got, tt.want)
+		}
+	}
+	if Match(nil, "github.com") {
+		t.Error("empty allowlist matched")
+	}
+}
+
+func TestEgressTarget(t *testing.T) {
+	allow := []string{"example.com", "*.example.org", "example.net:8443"}
+	tests := []struct {
+		method  string
+		host    string
+		target  string
+		allowed bool
+	}{
+		{http.MethodConnect, "example.com:443", "example.com", true},
+		{http.MethodConnect, "Example.COM:443", "example.com", true},
+		{http.MethodGet, "example.com", "example.com", true},
+		{http.MethodGet, "example.com:80", "example.com", true},
+		{http.MethodConnect, "api.example.org:443", "api.example.org", true},
+
+		// Other ports need an entry naming them
+		{http.MethodConnect, "example.com:8443", "example.com:8443", false},
+		{http.MethodConnect, "api.example.org:22", "api.example.org:22", false},
+		{http.MethodGet, "example.com:8080", "example.com:8080", false},
+		{http.MethodConnect, "example.net:8443", "example.net:8443", true},
+		{http.MethodConnect, "example.net:443", "example.net", false},
+	}
+	for _, tt := range tests {
+		target := egressTarget(tt.method, tt.host)
+		if target != tt.target {
+			t.Errorf("egressTarget(%s, %q) = %q, want %q", tt.method, tt.host, target, tt.target)
+			continue
+		}
+		if got := Match(allow, target); got != tt.allowed {
+			t.Errorf("%s %s: allowed = %v, want %v", tt.method, tt.host, got, tt.allowed)
+		}
+	}
+}
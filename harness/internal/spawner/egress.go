@@ -0,0 +1,138 @@
+package spawner
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// ChangeHandler is told about worker changes that happen outside a spawn or
+// exit, such as egress transitions
+type ChangeHandler func(event, workerID string)
+
+// OnChange registers a handler for worker change events
+func (s *Spawner) OnChange(h ChangeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changeHandlers = append(s.changeHandlers, h)
+}
+
+func (s *Spawner) changed(event, id string) {
+	s.mu.Lock()
+	handlers := s.changeHandlers
+	s.mu.Unlock()
+	for _, h := range handlers {
+		h(event, id)
+	}
+}
+
+// egressNetworks returns the networks a worker's granted privileges call for
+func (s *Spawner) egressNetworks(granted []string) []string {
+	var networks []string
+	for _, id := range granted {
+		if priv := s.state.Config().FindPrivilege(id); priv != nil && priv.Network != "" {
+			networks = append(networks, priv.Network)
+		}
+	}
+	return networks
+}
+
+// AttachEgress connects a running worker to the networks of its granted
+// privileges (e.g. web-access). Docker can add networks to a live
+// container, so the worker keeps running.
+func (s *Spawner) AttachEgress(id string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if w.ContainerID == "" || !w.IsActive() {
+		return fmt.Errorf("worker %s is %s", id, w.Status)
+	}
+	networks := s.egressNetworks(s.state.GrantedPrivileges(w.Project, w.TaskID))
+	if len(networks) == 0 {
+		return nil
+	}
+
+	s.setEgress(id, state.EgressAttaching)
+	if err := connect(w.ContainerID, networks); err != nil {
+		s.setEgress(id, state.EgressNone)
+		return err
+	}
+	s.setEgress(id, state.EgressAttached)
+	s.logger.Info("Worker attached to egress", "worker", id, "networks", strings.Join(networks, ","))
+	return nil
+}
+
+// DetachEgress disconnects a worker from every network but the sandbox,
+// e.g. when web-access is revoked or its task ends. Stopped containers can
+// be detached too, so a later restart doesn't bring egress back.
+func (s *Spawner) DetachEgress(id string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if w.ContainerID == "" {
+		s.setEgress(id, state.EgressNone)
+		return nil
+	}
+	networks, err := containerNetworks(w.ContainerID)
+	if err != nil {
+		return err
+	}
+
+	s.setEgress(id, state.EgressDetaching)
+	for _, n := range networks {
+		if n == SandboxNetwork {
+			continue
+		}
+		if err := exec.Command("docker", "network", "disconnect", "-f", n, w.ContainerID).Run(); err != nil {
+			s.setEgress(id, state.EgressAttached)
+			return fmt.Errorf("docker network disconnect %s: %w", n, exitDetail(err))
+		}
+	}
+	s.setEgress(id, state.EgressNone)
+	s.logger.Info("Worker detached from egress", "worker", id)
+	return nil
+}
+
+func (s *Spawner) setEgress(id string, egress state.EgressState) {
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		w.Egress = egress
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist egress state", "error", err)
+	}
+	event := "worker_egress_" + string(egress)
+	if egress == state.EgressNone {
+		event = "worker_egress_detached"
+	}
+	s.changed(event, id)
+}
+
+// connect attaches a container to networks, creating any that don't exist
+// yet. Egress networks are ordinary bridge networks, unlike the internal
+// sandbox.
+func connect(containerID string, networks []string) error {
+	for _, n := range networks {
+		if exec.Command("docker", "network", "inspect", n).Run() != nil {
+			if err := exec.Command("docker", "network", "create", n).Run(); err != nil {
+				return fmt.Errorf("docker network create %s: %w", n, exitDetail(err))
+			}
+		}
+		out, err := exec.Command("docker", "network", "connect", n, containerID).CombinedOutput()
+		if err != nil && !strings.Contains(string(out), "already exists") {
+			return fmt.Errorf("docker network connect %s: %w: %s", n, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+func containerNetworks(containerID string) ([]string, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{range $name, $_ := .NetworkSettings.Networks}}{{$name}} {{end}}", containerID).Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker inspect: %w", exitDetail(err))
+	}
+	return strings.Fields(string(out)), nil
+}
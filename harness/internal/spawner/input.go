@@ -0,0 +1,182 @@
+package spawner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+const (
+	// attachTimeout bounds how long docker attach may take to accept a turn
+	attachTimeout = 10 * time.Second
+	// attachFlush is how long docker attach is given to forward a turn
+	// before it is detached; the CLI keeps streaming output until killed
+	attachFlush = 2 * time.Second
+	// idleFinish is how long a worker with unanswered turns may sit silent
+	// after a result before it is treated as finished anyway
+	idleFinish = 30 * time.Second
+	// decisionPoll is how often a finished worker waiting on the director
+	// is checked. The first check also gives a request the worker ended its
+	// turn with time to register.
+	decisionPoll = 5 * time.Second
+)
+
+// Send delivers a message to a running frontier worker as a new user turn.
+// Local (OpenCode) workers run a single prompt and cannot be messaged.
+func (s *Spawner) Send(id, text string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if !w.IsActive() || w.ContainerID == "" {
+		return fmt.Errorf("worker %s is %s", id, w.Status)
+	}
+	if w.WorkerType == "local" {
+		return fmt.Errorf("worker %s is a local worker and does not accept messages", id)
+	}
+	return s.deliver(id, w.ContainerID, text)
+}
+
+// deliver writes one stream-json turn to a container's stdin through
+// docker attach. Containers run with -d -i, so detaching does not close
+// their stdin. The turn is counted once attach has taken it, and uncounted
+// if attach then fails before it is detached.
+func (s *Spawner) deliver(id, containerID, text string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker", "attach", "--sig-proxy=false", containerID)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = io.Discard
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("docker attach: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	written := make(chan error, 1)
+	go func() {
+		_, err := stdin.Write(stream.UserTurn(text))
+		stdin.Close()
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			return fmt.Errorf("docker attach: %w", err)
+		}
+	case err := <-exited:
+		return attachFailed(err, &stderr)
+	case <-time.After(attachTimeout):
+		return fmt.Errorf("docker attach: worker %s did not accept the turn within %s", id, attachTimeout)
+	}
+
+	s.mu.Lock()
+	s.pending[containerID]++
+	s.mu.Unlock()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			s.mu.Lock()
+			s.pending[containerID]--
+			s.mu.Unlock()
+			return attachFailed(err, &stderr)
+		}
+	case <-time.After(attachFlush):
+	}
+	return nil
+}
+
+// attachFailed describes a docker attach that exited before it was detached
+func attachFailed(err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		err = fmt.Errorf("exited before taking the turn")
+	}
+	return fmt.Errorf("docker attach: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+}
+
+// turnFinished records a stream-json result. Once every delivered turn has
+// been answered the worker is done and its container is stopped, unless it
+// is waiting on the director. Turns are counted per container so a
+// respawned worker starts from zero.
+func (s *Spawner) turnFinished(id, containerID string, ok bool) {
+	s.mu.Lock()
+	s.results[containerID] = ok
+	s.pending[containerID]--
+	left := s.pending[containerID]
+	s.mu.Unlock()
+
+	if left > 0 {
+		// Turns queued mid-turn may be answered together; don't wait forever
+		go s.finishIfIdle(id, containerID, left)
+		return
+	}
+	go s.finishWhenDecided(id, containerID, 0)
+}
+
+func (s *Spawner) finishIfIdle(id, containerID string, left int) {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return
+	}
+	last := w.LastOutput
+	time.Sleep(idleFinish)
+	w = s.state.GetWorker(id)
+	if w == nil || w.ContainerID != containerID || w.Status != state.WorkerRunning || !w.LastOutput.Equal(last) {
+		return
+	}
+	s.finishWhenDecided(id, containerID, left)
+}
+
+// finishWhenDecided stops a finished worker once nothing it asked the
+// director for is still open, so the answer can reach it as a new turn. It
+// gives up if a new turn is delivered meanwhile; that turn's result decides.
+func (s *Spawner) finishWhenDecided(id, containerID string, left int) {
+	ctx := s.runContext()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(decisionPoll):
+		}
+		s.mu.Lock()
+		delivered := s.pending[containerID] > left
+		s.mu.Unlock()
+		w := s.state.GetWorker(id)
+		if delivered || w == nil || w.ContainerID != containerID || !w.IsActive() {
+			return
+		}
+		if !s.awaitingDecision(id) {
+			s.finish(id, containerID)
+			return
+		}
+	}
+}
+
+// awaitingDecision reports whether a worker has an approval or privilege
+// request the director has not answered
+func (s *Spawner) awaitingDecision(id string) bool {
+	for _, a := range s.state.PendingApprovals("") {
+		if a.WorkerID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Spawner) finish(id, containerID string) {
+	if err := exec.Command("docker", "stop", containerID).Run(); err != nil {
+		s.logger.Warn("Failed to stop finished worker", "worker", id, "error", exitDetail(err))
+	}
+}
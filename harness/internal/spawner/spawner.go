@@ -0,0 +1,615 @@
+package spawner
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/isolation"
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+const (
+	// FrontierImage runs Claude Code (see the dev-sandbox service in compose.yaml)
+	FrontierImage = "ai-dev-sandbox:latest"
+	// LocalImage runs OpenCode against Ollama (see the local-sandbox service)
+	LocalImage = "local-worker:latest"
+	// SandboxNetwork is the internal-only network workers are attached to
+	SandboxNetwork = "workspace-sandbox"
+	// InternalNetwork connects the harness to the other services; workers
+	// are never attached to it
+	InternalNetwork = "workspace-internal"
+
+	// ProxyHost is the harness's name on the sandbox network, where workers
+	// reach the egress proxy
+	ProxyHost = "harness"
+	// noProxy lists sandbox services workers reach directly
+	noProxy = "ollama,litellm,harness,localhost,127.0.0.1"
+
+	// ProjectMount is where the project is mounted inside a worker
+	ProjectMount = "/workspace/project"
+
+	containerPrefix = "workspace-worker-"
+	labelWorker     = "workspace-agent.worker"
+	labelProject    = "workspace-agent.project"
+
+	// crashTailLines of a crashed worker's output are posted to its thread
+	crashTailLines = 200
+)
+
+// Request describes a worker to spawn
+type Request struct {
+	Project    string `json:"project"`     // alias from workspace.yaml
+	WorkerType string `json:"worker_type"` // frontier | local
+	ThreadID   string `json:"thread_id"`
+	Prompt     string `json:"prompt"`
+	// TaskID reuses an existing task's workspace; when empty the active
+	// task for the thread is reused or a new task is created
+	TaskID string `json:"task_id,omitempty"`
+}
+
+// OutputHandler receives each line a worker writes. ev is the parsed
+// stream-json event for frontier workers and nil for plain output.
+type OutputHandler func(workerID, line string, ev *stream.Event)
+
+// Spawner launches worker containers and tracks their output
+type Spawner struct {
+	state  *state.AppState
+	mm     *mattermost.Bridge
+	logger *log.Logger
+
+	mu       sync.Mutex
+	ctx      context.Context
+	handlers []OutputHandler
+	// changeHandlers are told about egress transitions and similar changes
+	changeHandlers  []ChangeHandler
+	taskEndHandlers []func(taskID string)
+	patchHandlers   []func(taskID, stat string)
+	pending         map[string]int  // container ID -> turns sent but not yet finished
+	results         map[string]bool // container ID -> whether the last turn succeeded
+}
+
+// New creates a worker spawner
+func New(appState *state.AppState, mm *mattermost.Bridge, logger *log.Logger) *Spawner {
+	return &Spawner{
+		state:   appState,
+		mm:      mm,
+		logger:  logger,
+		ctx:     context.Background(),
+		pending: make(map[string]int),
+		results: make(map[string]bool),
+	}
+}
+
+// Run resumes output tracking for workers recovered from state and keeps
+// the spawner bound to the harness lifetime.
+func (s *Spawner) Run(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	for _, w := range s.state.ListWorkers() {
+		if w.ContainerID == "" || !w.IsActive() {
+			continue
+		}
+		s.logger.Info("Resuming worker", "worker", w.ID, "container", shortID(w.ContainerID))
+		go s.follow(ctx, w.ID, w.ContainerID, time.Now())
+	}
+
+	<-ctx.Done()
+}
+
+// Spawn starts a worker container for a project and registers it in state
+func (s *Spawner) Spawn(ctx context.Context, req Request) (*state.Worker, error) {
+	cfg := s.state.Config()
+	project := cfg.FindProject(req.Project)
+	if project == nil {
+		return nil, fmt.Errorf("unknown project %q", req.Project)
+	}
+	if req.WorkerType == "" {
+		req.WorkerType = "frontier"
+	}
+	if req.WorkerType != "frontier" && req.WorkerType != "local" {
+		return nil, fmt.Errorf("unknown worker type %q (want frontier or local)", req.WorkerType)
+	}
+	if strings.TrimSpace(req.Prompt) == "" {
+		return nil, fmt.Errorf("prompt is required")
+	}
+
+	task, err := s.task(project, req)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := isolation.Prepare(project, task.ID)
+	if err != nil {
+		return nil, fmt.Errorf("prepare workspace: %w", err)
+	}
+	s.state.UpdateTask(task.ID, func(t *state.Task) {
+		t.Isolation = ws.Mode
+		t.Branch = ws.Branch
+		t.WorktreePath = ws.Path
+		if t.BaseCommit == "" {
+			t.BaseCommit = ws.Base
+		}
+	})
+
+	req.TaskID = task.ID
+	id := newID(project.Alias)
+	containerID, err := s.start(ctx, id, project, ws, req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	w := &state.Worker{
+		ID:           id,
+		ContainerID:  containerID,
+		Project:      project.Alias,
+		ThreadID:     req.ThreadID,
+		WorkerType:   req.WorkerType,
+		Status:       state.WorkerRunning,
+		SpawnedAt:    now,
+		LastOutput:   now,
+		SpawnCount:   1,
+		WorktreePath: ws.Path,
+		TaskID:       task.ID,
+		Prompt:       req.Prompt,
+		Privileges:   s.state.GrantedPrivileges(project.Alias, task.ID),
+	}
+	if len(s.egressNetworks(w.Privileges)) > 0 {
+		w.Egress = state.EgressAttached
+	}
+	s.state.AddWorker(w)
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after spawn", "error", err)
+	}
+	s.logger.Info("Worker spawned", "worker", id, "task", task.ID, "project", project.Alias, "type", req.WorkerType, "container", shortID(containerID))
+
+	go s.follow(s.runContext(), id, containerID, now)
+
+	return w, nil
+}
+
+// OnOutput registers a handler for worker output lines. Handlers run on the
+// worker's output goroutine and should not block.
+func (s *Spawner) OnOutput(h OutputHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, h)
+}
+
+// Pause freezes a worker's container, e.g. while a budget extension is pending
+func (s *Spawner) Pause(id string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if w.Status == state.WorkerPaused {
+		return nil
+	}
+	if err := exec.Command("docker", "pause", w.ContainerID).Run(); err != nil {
+		return fmt.Errorf("docker pause: %w", exitDetail(err))
+	}
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		w.Status = state.WorkerPaused
+	})
+	s.logger.Info("Worker paused", "worker", id)
+	return nil
+}
+
+// Resume unfreezes a paused worker
+func (s *Spawner) Resume(id string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if w.Status != state.WorkerPaused {
+		return nil
+	}
+	if err := exec.Command("docker", "unpause", w.ContainerID).Run(); err != nil {
+		return fmt.Errorf("docker unpause: %w", exitDetail(err))
+	}
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		w.Status = state.WorkerRunning
+		w.LastOutput = time.Now() // don't count the pause as a stall
+	})
+	s.logger.Info("Worker resumed", "worker", id)
+	return nil
+}
+
+// Respawn replaces a failed worker's container with a fresh one on the same
+// task workspace, keeping the worker ID and incrementing its SpawnCount.
+// prompt replaces the worker's original prompt for the new container.
+func (s *Spawner) Respawn(ctx context.Context, id, prompt string) (*state.Worker, error) {
+	return s.replace(ctx, id, prompt, true)
+}
+
+// Recreate replaces a healthy worker's container, e.g. to apply mounts or
+// environment Docker cannot add to a running container. It does not count
+// as a spawn attempt.
+func (s *Spawner) Recreate(ctx context.Context, id, prompt string) (*state.Worker, error) {
+	return s.replace(ctx, id, prompt, false)
+}
+
+// HandoffPrompt points a replacement container at the worker's HANDOFF.md.
+// reason completes "You are replacing worker <id>, which ...".
+func HandoffPrompt(w *state.Worker, reason string) string {
+	return fmt.Sprintf("You are replacing worker %s, which %s. "+
+		"Read %s/HANDOFF.md first and continue from where it left off; if it is missing or stale, inspect the working tree and git log to reorient. "+
+		"Keep HANDOFF.md current as you go.\n\nOriginal task:\n%s",
+		w.ID, reason, ProjectMount, w.Prompt)
+}
+
+func (s *Spawner) replace(ctx context.Context, id, prompt string, attempt bool) (*state.Worker, error) {
+	old := s.state.GetWorker(id)
+	if old == nil {
+		return nil, fmt.Errorf("unknown worker %q", id)
+	}
+	project := s.state.Config().FindProject(old.Project)
+	if project == nil {
+		return nil, fmt.Errorf("unknown project %q", old.Project)
+	}
+	ws, err := isolation.Prepare(project, old.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("prepare workspace: %w", err)
+	}
+	if ws.Base != "" {
+		s.state.UpdateTask(old.TaskID, func(t *state.Task) {
+			if t.BaseCommit == "" {
+				t.BaseCommit = ws.Base
+			}
+		})
+	}
+
+	// Detach the old container first so its follower doesn't record the
+	// exit as the worker's final status
+	var oldContainer string
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		oldContainer = w.ContainerID
+		w.ContainerID = ""
+	})
+	if oldContainer != "" {
+		if err := exec.Command("docker", "rm", "-f", oldContainer).Run(); err != nil {
+			s.logger.Warn("Failed to remove old worker container", "worker", id, "error", exitDetail(err))
+		}
+	}
+
+	req := Request{
+		Project:    old.Project,
+		WorkerType: old.WorkerType,
+		ThreadID:   old.ThreadID,
+		Prompt:     prompt,
+		TaskID:     old.TaskID,
+	}
+	containerID, err := s.start(ctx, id, project, ws, req)
+	if err != nil {
+		s.state.UpdateWorker(id, func(w *state.Worker) {
+			w.Status = state.WorkerFailed
+		})
+		return nil, err
+	}
+
+	granted := s.state.GrantedPrivileges(old.Project, old.TaskID)
+	egress := state.EgressNone
+	if len(s.egressNetworks(granted)) > 0 {
+		egress = state.EgressAttached
+	}
+	now := time.Now()
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		w.ContainerID = containerID
+		w.Status = state.WorkerRunning
+		w.Privileges = granted
+		w.Egress = egress
+		w.SpawnedAt = now
+		w.LastOutput = now
+		if attempt {
+			w.SpawnCount++
+		}
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after respawn", "error", err)
+	}
+	s.logger.Info("Worker replaced", "worker", id, "container", shortID(containerID), "attempt", attempt)
+
+	go s.follow(s.runContext(), id, containerID, now)
+	return s.state.GetWorker(id), nil
+}
+
+// Kill force-removes a worker's container and marks it failed
+func (s *Spawner) Kill(id string) error {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return fmt.Errorf("unknown worker %q", id)
+	}
+	if w.ContainerID != "" {
+		if err := exec.Command("docker", "rm", "-f", w.ContainerID).Run(); err != nil {
+			return fmt.Errorf("docker rm: %w", exitDetail(err))
+		}
+	}
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		w.Status = state.WorkerFailed
+	})
+	s.logger.Info("Worker killed", "worker", id)
+	return nil
+}
+
+// start runs a worker container and hands it its prompt. Frontier workers
+// read the prompt as their first stream-json turn so later messages can
+// follow on the same stdin.
+func (s *Spawner) start(ctx context.Context, id string, project *state.Project, ws *isolation.Workspace, req Request) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", s.runArgs(id, project, ws, req)...).Output()
+	if err != nil {
+		return "", fmt.Errorf("docker run: %w", exitDetail(err))
+	}
+	containerID := strings.TrimSpace(string(out))
+
+	// Networks must be attached before the worker starts on its prompt
+	if err := connect(containerID, s.egressNetworks(s.state.GrantedPrivileges(project.Alias, req.TaskID))); err != nil {
+		exec.Command("docker", "rm", "-f", containerID).Run()
+		return "", err
+	}
+
+	if req.WorkerType != "local" {
+		if err := s.deliver(id, containerID, req.Prompt); err != nil {
+			exec.Command("docker", "rm", "-f", containerID).Run()
+			return "", fmt.Errorf("deliver prompt: %w", err)
+		}
+	}
+	return containerID, nil
+}
+
+// runContext returns the harness lifetime context followers run under
+func (s *Spawner) runContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx
+}
+
+func (s *Spawner) runArgs(id string, project *state.Project, ws *isolation.Workspace, req Request) []string {
+	cfg := s.state.Config()
+	args := []string{
+		"run", "-d", "-i",
+		"--name", containerPrefix + id,
+		"--network", SandboxNetwork,
+		"--label", labelWorker + "=" + id,
+		"--label", labelProject + "=" + project.Alias,
+		"-v", ws.Path + ":" + ProjectMount + ":rw",
+		"-w", ProjectMount,
+		"-e", "WORKSPACE_WORKER_ID=" + id,
+	}
+	proxy := fmt.Sprintf("http://%s:%d", ProxyHost, cfg.Egress.ProxyPort)
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		args = append(args, "-e", name+"="+proxy)
+	}
+	args = append(args, "-e", "NO_PROXY="+noProxy, "-e", "no_proxy="+noProxy)
+	for _, path := range ws.Extra {
+		args = append(args, "-v", path+":"+path+":rw")
+	}
+	args = append(args, s.privilegeArgs(s.state.GrantedPrivileges(project.Alias, req.TaskID))...)
+
+	switch req.WorkerType {
+	case "local":
+		args = append(args,
+			"-e", "OPENAI_API_BASE=http://ollama:11434/v1",
+			"-e", "OPENAI_API_KEY=ollama",
+			LocalImage,
+			"run", req.Prompt,
+		)
+	default:
+		args = append(args,
+			"-e", "CLAUDE_CONFIG_DIR=/home/dev/.claude",
+			"-e", "ANTHROPIC_API_KEY",
+			"-e", "CLAUDE_CODE_EXPERIMENTAL_AGENT_TEAMS=1",
+			FrontierImage,
+			"claude",
+		)
+		if cfg.Models.FrontierWorker != "" {
+			args = append(args, "--model", cfg.Models.FrontierWorker)
+		}
+		// Structured output carries per-message token usage for budgeting;
+		// structured input lets the harness send turns after the prompt
+		args = append(args, "-p",
+			"--input-format", "stream-json",
+			"--output-format", "stream-json", "--verbose")
+	}
+	return args
+}
+
+// privilegeArgs realizes granted privileges as container options: token_env
+// is passed through from the harness environment and mount is bind-mounted
+// under /workspace/context/<privilege-id>
+func (s *Spawner) privilegeArgs(granted []string) []string {
+	var args []string
+	for _, id := range granted {
+		priv := s.state.Config().FindPrivilege(id)
+		if priv == nil {
+			continue
+		}
+		if priv.TokenEnv != "" {
+			if _, ok := os.LookupEnv(priv.TokenEnv); !ok {
+				s.logger.Warn("Privilege token not set in harness environment", "privilege", id, "env", priv.TokenEnv)
+			}
+			args = append(args, "-e", priv.TokenEnv)
+		}
+		if priv.Mount != "" {
+			mode := "rw"
+			if priv.Mode == "readonly" {
+				mode = "ro"
+			}
+			args = append(args, "-v", state.ExpandHome(priv.Mount)+":"+PrivilegeMount(id)+":"+mode)
+		}
+	}
+	return args
+}
+
+// PrivilegeMount is where a privilege's mount appears inside a worker
+func PrivilegeMount(privilegeID string) string {
+	return "/workspace/context/" + privilegeID
+}
+
+// follow streams a worker's container output from since, recording activity
+// until the container exits, then records the final status.
+func (s *Spawner) follow(ctx context.Context, id, containerID string, since time.Time) {
+	cmd := exec.CommandContext(ctx, "docker", "logs", "-f", "--since", since.Format(time.RFC3339Nano), containerID)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.logger.Error("Failed to follow worker", "worker", id, "error", err)
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		s.logger.Error("Failed to follow worker", "worker", id, "error", err)
+		return
+	}
+
+	s.scan(id, containerID, stdout)
+	cmd.Wait()
+
+	if ctx.Err() != nil {
+		return // harness shutting down, worker keeps running
+	}
+
+	status := state.WorkerFailed
+	s.mu.Lock()
+	ok, reported := s.results[containerID]
+	delete(s.results, containerID)
+	delete(s.pending, containerID)
+	s.mu.Unlock()
+	if reported {
+		// The harness stops frontier workers after their last turn, so the
+		// container exit code reflects the stop rather than the outcome
+		if ok {
+			status = state.WorkerDone
+		}
+	} else if code, err := exitCode(containerID); err == nil && code == 0 {
+		status = state.WorkerDone
+	}
+	// Killed workers are already marked failed; only an unexpected exit crashed
+	var crashed bool
+	s.state.UpdateWorker(id, func(w *state.Worker) {
+		if w.ContainerID == containerID && w.Status != state.WorkerFailed {
+			w.Status = status
+			crashed = status == state.WorkerFailed
+		}
+	})
+	s.logger.Info("Worker exited", "worker", id, "status", status)
+	if crashed {
+		s.postCrashTail(id, containerID)
+	}
+
+	if w := s.state.GetWorker(id); w != nil {
+		if w.Egress != state.EgressNone && w.ContainerID == containerID {
+			if err := s.DetachEgress(id); err != nil {
+				s.logger.Warn("Failed to detach exited worker from egress", "worker", id, "error", err)
+			}
+		}
+		s.taskWorkerExited(w.TaskID)
+	}
+}
+
+func (s *Spawner) scan(id, containerID string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s.state.UpdateWorker(id, func(w *state.Worker) {
+			w.LastOutput = time.Now()
+		})
+		s.logger.Debug("Worker output", "worker", id, "line", line)
+
+		ev, _ := stream.Parse(line)
+		if ev != nil && ev.Type == stream.TypeResult {
+			s.turnFinished(id, containerID, !ev.IsError)
+		}
+
+		s.mu.Lock()
+		handlers := s.handlers
+		s.mu.Unlock()
+		for _, h := range handlers {
+			h(id, line, ev)
+		}
+	}
+}
+
+// postCrashTail attaches the end of a crashed worker's output to its thread
+func (s *Spawner) postCrashTail(id, containerID string) {
+	w := s.state.GetWorker(id)
+	if w == nil {
+		return
+	}
+	out, err := exec.Command("docker", "logs", "--tail", strconv.Itoa(crashTailLines), containerID).CombinedOutput()
+	if err != nil {
+		s.logger.Warn("Failed to read crashed worker's output", "worker", id, "error", err)
+		return
+	}
+	s.attach(w.ThreadID, fmt.Sprintf("Worker `%s` exited unexpectedly. The last %d lines of its output are attached.", id, crashTailLines),
+		id+"-crash.log", out)
+}
+
+// attach posts a file to a worker's task thread when Mattermost is configured
+func (s *Spawner) attach(threadID, message, name string, data []byte) {
+	if s.mm == nil || threadID == "" {
+		return
+	}
+	s.mm.PostFile("", threadID, message, name, data)
+}
+
+// NetworkIP returns a container's address on a Docker network
+func NetworkIP(container, network string) (string, error) {
+	out, err := exec.Command("docker", "inspect", "-f",
+		fmt.Sprintf(`{{with index .NetworkSettings.Networks %q}}{{.IPAddress}}{{end}}`, network), container).Output()
+	if err != nil {
+		return "", err
+	}
+	addr := strings.TrimSpace(string(out))
+	if addr == "" {
+		return "", fmt.Errorf("%s is not on network %s", container, network)
+	}
+	return addr, nil
+}
+
+func exitCode(containerID string) (int, error) {
+	out, err := exec.Command("docker", "inspect", "-f", "{{.State.ExitCode}}", containerID).Output()
+	if err != nil {
+		return 0, err
+	}
+	var code int
+	_, err = fmt.Sscanf(strings.TrimSpace(string(out)), "%d", &code)
+	return code, err
+}
+
+// exitDetail folds docker's stderr into the error so callers see why it failed
+func exitDetail(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return err
+}
+
+// newID returns a unique ID prefixed with the project alias, used for both
+// workers and tasks. Six random bytes keep collisions out of reach across
+// every worker and task a workspace will ever see.
+func newID(alias string) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return alias + "-" + hex.EncodeToString(b)
+}
+
+func shortID(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
+	}
+	return containerID
+}
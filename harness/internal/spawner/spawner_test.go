@@ -0,0 +1,21 @@
+package spawner
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	format := regexp.MustCompile(`^api-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := newID("api")
+		if !format.MatchString(id) {
+			t.Fatalf("newID = %q, want api- and 12 hex digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("newID repeated %q after %d IDs", id, i)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,274 @@
+package spawner
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/isolation"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// ArchiveTask releases a task's workspace once none of its workers are
+// still running. force discards uncommitted changes in a worktree.
+func (s *Spawner) ArchiveTask(id string, force bool) error {
+	task := s.state.GetTask(id)
+	if task == nil {
+		return fmt.Errorf("unknown task %q", id)
+	}
+	for _, w := range s.state.WorkersForTask(id) {
+		if w.IsActive() {
+			return fmt.Errorf("worker %s is still %s", w.ID, w.Status)
+		}
+	}
+	project := s.state.Config().FindProject(task.Project)
+	if project == nil {
+		return fmt.Errorf("unknown project %q", task.Project)
+	}
+	if err := isolation.Release(project, task, force); err != nil {
+		return err
+	}
+	s.state.UpdateTask(id, func(t *state.Task) {
+		t.Status = state.TaskArchived
+		if t.CompletedAt.IsZero() {
+			t.CompletedAt = time.Now()
+		}
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after archive", "error", err)
+	}
+	s.logger.Info("Task archived", "task", id, "project", task.Project)
+	if task.Status == state.TaskActive {
+		s.endTask(id)
+	}
+	return nil
+}
+
+// CompleteTask marks a task done once none of its workers are still
+// running. Its workspace is kept for review until it is archived.
+func (s *Spawner) CompleteTask(id string) error {
+	task := s.state.GetTask(id)
+	if task == nil {
+		return fmt.Errorf("unknown task %q", id)
+	}
+	if task.Status != state.TaskActive {
+		return fmt.Errorf("task %s is already %s", id, task.Status)
+	}
+	for _, w := range s.state.WorkersForTask(id) {
+		if w.IsActive() {
+			return fmt.Errorf("worker %s is still %s", w.ID, w.Status)
+		}
+	}
+	s.state.UpdateTask(id, func(t *state.Task) {
+		t.Status = state.TaskDone
+		t.CompletedAt = time.Now()
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after task completion", "error", err)
+	}
+	s.logger.Info("Task completed", "task", id, "project", task.Project)
+	s.endTask(id)
+	return nil
+}
+
+// OnTaskEnd registers a handler called when a task completes or is
+// archived while still active, e.g. to expire per-task grants
+func (s *Spawner) OnTaskEnd(h func(taskID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taskEndHandlers = append(s.taskEndHandlers, h)
+}
+
+// OnPatchReady registers a handler called when a copy-isolated task's
+// patch is ready for review, e.g. to ask the director to merge it back.
+// Without one the patch is announced with the API calls that apply it.
+func (s *Spawner) OnPatchReady(h func(taskID, stat string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patchHandlers = append(s.patchHandlers, h)
+}
+
+// endTask tears down per-task access left on the task's workers and tells
+// the task end handlers
+func (s *Spawner) endTask(id string) {
+	for _, w := range s.state.WorkersForTask(id) {
+		if w.Egress == state.EgressNone {
+			continue
+		}
+		if err := s.DetachEgress(w.ID); err != nil {
+			s.logger.Warn("Failed to detach worker from egress at task end", "worker", w.ID, "error", err)
+		}
+	}
+
+	s.mu.Lock()
+	handlers := s.taskEndHandlers
+	s.mu.Unlock()
+	for _, h := range handlers {
+		h(id)
+	}
+}
+
+// task resolves the task a spawn request belongs to, creating one if needed
+func (s *Spawner) task(project *state.Project, req Request) (*state.Task, error) {
+	if req.TaskID != "" {
+		t := s.state.GetTask(req.TaskID)
+		if t == nil {
+			return nil, fmt.Errorf("unknown task %q", req.TaskID)
+		}
+		if t.Status != state.TaskActive {
+			return nil, fmt.Errorf("task %s is %s", t.ID, t.Status)
+		}
+		return t, nil
+	}
+	if t := s.state.ActiveTaskForThread(req.ThreadID, project.Alias); t != nil {
+		return t, nil
+	}
+	t := &state.Task{
+		ID:        newID(project.Alias),
+		Project:   project.Alias,
+		ThreadID:  req.ThreadID,
+		Status:    state.TaskActive,
+		CreatedAt: time.Now(),
+	}
+	s.state.AddTask(t)
+	return t, nil
+}
+
+// SnapshotTask writes the reviewable patch for a copy-isolated task and
+// returns its diffstat. An empty diffstat means the copy is unchanged.
+func (s *Spawner) SnapshotTask(id string) (string, error) {
+	task := s.state.GetTask(id)
+	if task == nil {
+		return "", fmt.Errorf("unknown task %q", id)
+	}
+	if task.Isolation != isolation.ModeCopy {
+		return "", fmt.Errorf("task %s uses %s isolation, not copy", id, task.Isolation)
+	}
+	patchPath := isolation.PatchPath(id)
+	stat, err := isolation.WriteCopyPatch(task.WorktreePath, task.BaseCommit, patchPath)
+	if err != nil {
+		return "", err
+	}
+	s.state.UpdateTask(id, func(t *state.Task) {
+		if stat == "" {
+			t.Patch = ""
+			t.PatchStatus = ""
+			return
+		}
+		t.Patch = patchPath
+		t.PatchStatus = state.PatchPending
+	})
+	return stat, nil
+}
+
+// ApplyTask applies a copy-isolated task's patch to the original project
+func (s *Spawner) ApplyTask(id string) error {
+	task, project, err := s.pendingPatch(id)
+	if err != nil {
+		return err
+	}
+	if err := isolation.ApplyPatch(project.HostPath(), task.Patch); err != nil {
+		return err
+	}
+	s.state.UpdateTask(id, func(t *state.Task) {
+		t.PatchStatus = state.PatchApplied
+	})
+	s.logger.Info("Task patch applied", "task", id, "project", project.Alias)
+	s.mm.Notify(task.ThreadID, fmt.Sprintf("Patch for task `%s` applied to `%s`", id, project.Alias))
+	return s.state.Save()
+}
+
+// DiscardTask throws away a copy-isolated task's patch without applying it
+func (s *Spawner) DiscardTask(id string) error {
+	task, _, err := s.pendingPatch(id)
+	if err != nil {
+		return err
+	}
+	s.state.UpdateTask(id, func(t *state.Task) {
+		t.PatchStatus = state.PatchDiscarded
+	})
+	s.logger.Info("Task patch discarded", "task", id)
+	s.mm.Notify(task.ThreadID, fmt.Sprintf("Patch for task `%s` discarded", id))
+	return s.state.Save()
+}
+
+func (s *Spawner) pendingPatch(id string) (*state.Task, *state.Project, error) {
+	task := s.state.GetTask(id)
+	if task == nil {
+		return nil, nil, fmt.Errorf("unknown task %q", id)
+	}
+	if task.PatchStatus != state.PatchPending {
+		return nil, nil, fmt.Errorf("task %s has no pending patch", id)
+	}
+	project := s.state.Config().FindProject(task.Project)
+	if project == nil {
+		return nil, nil, fmt.Errorf("unknown project %q", task.Project)
+	}
+	return task, project, nil
+}
+
+// taskWorkerExited produces the copy patch, or uploads the branch diff of
+// a worktree task, once the last worker on the task has exited
+func (s *Spawner) taskWorkerExited(taskID string) {
+	task := s.state.GetTask(taskID)
+	if task == nil || (task.Isolation != isolation.ModeCopy && task.Isolation != isolation.ModeWorktree) {
+		return
+	}
+	for _, w := range s.state.WorkersForTask(taskID) {
+		if w.IsActive() {
+			return
+		}
+	}
+	if task.Isolation == isolation.ModeWorktree {
+		s.uploadWorktreeDiff(task)
+		return
+	}
+	stat, err := s.SnapshotTask(taskID)
+	if err != nil {
+		s.logger.Error("Failed to snapshot task", "task", taskID, "error", err)
+		return
+	}
+	if stat == "" {
+		s.mm.Notify(task.ThreadID, fmt.Sprintf("Task `%s` finished with no changes", taskID))
+		return
+	}
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after snapshot", "error", err)
+	}
+	if patch, err := os.ReadFile(isolation.PatchPath(taskID)); err == nil {
+		s.attach(task.ThreadID, fmt.Sprintf("Patch for task `%s`:", taskID), taskID+".patch", patch)
+	} else {
+		s.logger.Warn("Failed to read task patch", "task", taskID, "error", err)
+	}
+
+	s.mu.Lock()
+	handlers := s.patchHandlers
+	s.mu.Unlock()
+	if len(handlers) == 0 {
+		s.mm.Notify(task.ThreadID, fmt.Sprintf("Task `%s` finished. Patch ready for review:\n```\n%s\n```\nApply with `POST /api/tasks/%s/apply` or discard with `POST /api/tasks/%s/discard`.", taskID, stat, taskID, taskID))
+		return
+	}
+	for _, h := range handlers {
+		h(taskID, stat)
+	}
+}
+
+// uploadWorktreeDiff attaches what a worktree task changed on its branch,
+// committed or not, to the task thread for review
+func (s *Spawner) uploadWorktreeDiff(task *state.Task) {
+	if task.BaseCommit == "" {
+		s.logger.Warn("Worktree task has no base commit to diff against", "task", task.ID)
+		return
+	}
+	patch, stat, err := isolation.Diff(task.WorktreePath, task.BaseCommit)
+	if err != nil {
+		s.logger.Error("Failed to diff task worktree", "task", task.ID, "error", err)
+		return
+	}
+	if patch == "" {
+		s.mm.Notify(task.ThreadID, fmt.Sprintf("Task `%s` finished with no changes", task.ID))
+		return
+	}
+	s.attach(task.ThreadID, fmt.Sprintf("Task `%s` finished. Changes on branch `%s`:\n```\n%s\n```", task.ID, task.Branch, stat),
+		task.ID+".patch", []byte(patch))
+}
@@ -0,0 +1,124 @@
+package state
+
+import (
+	"net"
+	"strings"
+)
+
+// Role is what a person may do through Mattermost or the web UI
+type Role string
+
+const (
+	// RoleDirector steers the manager and runs harness commands
+	RoleDirector Role = "director"
+	// RoleApprover decides approval requests
+	RoleApprover Role = "approver"
+	// RoleObserver may only watch
+	RoleObserver Role = "observer"
+)
+
+// Access declares who holds each role. Members are Mattermost usernames or
+// Keycloak realm roles/groups (e.g. "director", "viewer" in the bundled
+// workspace-agent realm). Groups are matched where a Keycloak identity is
+// known, i.e. the web UI behind the Caddy auth gate.
+type Access struct {
+	Directors Members `yaml:"directors" json:"directors"`
+	Approvers Members `yaml:"approvers" json:"approvers"`
+	Observers Members `yaml:"observers" json:"observers"`
+	// UserHeader and GroupsHeader carry the identity the auth gate in
+	// front of the web UI injects
+	UserHeader   string `yaml:"user_header" json:"user_header"`
+	GroupsHeader string `yaml:"groups_header" json:"groups_header"`
+	// TrustedProxies are the auth gates (IPs, CIDRs or hostnames) allowed
+	// to set those headers. Headers from anywhere else are ignored.
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies"`
+}
+
+// Members of a role, by username or group
+type Members struct {
+	Users  []string `yaml:"users" json:"users"`
+	Groups []string `yaml:"groups" json:"groups"`
+}
+
+func (m Members) empty() bool {
+	return len(m.Users) == 0 && len(m.Groups) == 0
+}
+
+func (m Members) has(username string, groups []string) bool {
+	username = strings.TrimPrefix(username, "@")
+	for _, u := range m.Users {
+		if username != "" && strings.EqualFold(strings.TrimPrefix(u, "@"), username) {
+			return true
+		}
+	}
+	for _, g := range m.Groups {
+		for _, have := range groups {
+			if strings.EqualFold(strings.TrimPrefix(have, "/"), strings.TrimPrefix(g, "/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Enforced reports whether any role has members. Until one does, everyone
+// is treated as a director, as before roles existed.
+func (a *Access) Enforced() bool {
+	return !a.Directors.empty() || !a.Approvers.empty() || !a.Observers.empty()
+}
+
+// Allows reports whether a user holds a role. Roles nest: directors may
+// also approve, and everyone with a role may observe.
+func (a *Access) Allows(role Role, username string, groups []string) bool {
+	if !a.Enforced() {
+		return true
+	}
+	director := a.Directors.has(username, groups)
+	switch role {
+	case RoleDirector:
+		return director
+	case RoleApprover:
+		return director || a.Approvers.has(username, groups)
+	case RoleObserver:
+		return director || a.Approvers.has(username, groups) || a.Observers.has(username, groups)
+	}
+	return false
+}
+
+// TrustsProxy reports whether a request from remoteAddr (host:port) came
+// through a configured auth gate, so its identity headers can be believed
+func (a *Access) TrustsProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range a.TrustedProxies {
+		if _, cidr, err := net.ParseCIDR(p); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if trusted := net.ParseIP(p); trusted != nil {
+			if trusted.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		// A hostname, e.g. the caddy service, whose address Docker assigns
+		addrs, err := net.LookupIP(p)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
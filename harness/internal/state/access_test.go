@@ -0,0 +1,72 @@
+package state
+
+import "testing"
+
+func TestAccessAllows(t *testing.T) {
+	access := &Access{
+		Directors: Members{Users: []string{"@alice"}, Groups: []string{"/director"}},
+		Approvers: Members{Users: []string{"bob"}},
+		Observers: Members{Groups: []string{"viewer"}},
+	}
+	tests := []struct {
+		name     string
+		access   *Access
+		role     Role
+		username string
+		groups   []string
+		want     bool
+	}{
+		{"open access allows anyone", &Access{}, RoleDirector, "mallory", nil, true},
+		{"open access allows no identity", &Access{}, RoleDirector, "", nil, true},
+
+		{"director by username", access, RoleDirector, "alice", nil, true},
+		{"username is case-insensitive", access, RoleDirector, "ALICE", nil, true},
+		{"username may have @", access, RoleDirector, "@alice", nil, true},
+		{"director by group", access, RoleDirector, "carol", []string{"director"}, true},
+		{"group may have /", access, RoleDirector, "carol", []string{"/Director"}, true},
+		{"director may approve", access, RoleApprover, "alice", nil, true},
+		{"director may observe", access, RoleObserver, "alice", nil, true},
+
+		{"approver may approve", access, RoleApprover, "bob", nil, true},
+		{"approver may observe", access, RoleObserver, "bob", nil, true},
+		{"approver may not direct", access, RoleDirector, "bob", nil, false},
+
+		{"observer may observe", access, RoleObserver, "dave", []string{"viewer"}, true},
+		{"observer may not approve", access, RoleApprover, "dave", []string{"viewer"}, false},
+		{"observer may not direct", access, RoleDirector, "dave", []string{"viewer"}, false},
+
+		{"stranger may not observe", access, RoleObserver, "mallory", nil, false},
+		{"no identity may not observe", access, RoleObserver, "", nil, false},
+		{"group name is not a username", access, RoleDirector, "director", nil, false},
+		{"unknown role", access, Role("admin"), "alice", nil, false},
+	}
+	for _, tt := range tests {
+		if got := tt.access.Allows(tt.role, tt.username, tt.groups); got != tt.want {
+			t.Errorf("%s: Allows(%s, %q, %v) = %v, want %v", tt.name, tt.role, tt.username, tt.groups, got, tt.want)
+		}
+	}
+}
+
+func TestAccessTrustsProxy(t *testing.T) {
+	access := &Access{TrustedProxies: []string{"172.20.0.0/16", "10.0.0.5", "localhost"}}
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{"172.20.3.4:51234", true},
+		{"172.21.0.1:51234", false},
+		{"10.0.0.5:443", true},
+		{"10.0.0.6:443", false},
+		{"127.0.0.1:8080", true},
+		{"10.0.0.5", true},
+		{"not-an-address", false},
+	}
+	for _, tt := range tests {
+		if got := access.TrustsProxy(tt.remoteAddr); got != tt.want {
+			t.Errorf("TrustsProxy(%q) = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+	if (&Access{}).TrustsProxy("127.0.0.1:8080") {
+		t.Error("TrustsProxy with no trusted proxies = true, want false")
+	}
+}
@@ -2,6 +2,8 @@ package state
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -26,9 +28,36 @@ func LoadConfig(path string) (*Config, error) {
 	if cfg.Supervision.TokenBudgetPerTask == 0 {
 		cfg.Supervision.TokenBudgetPerTask = 100000
 	}
+	if cfg.Supervision.TokenWarnFraction == 0 {
+		cfg.Supervision.TokenWarnFraction = 0.8
+	}
 	if cfg.Supervision.ResourceSampleIntervalSec == 0 {
 		cfg.Supervision.ResourceSampleIntervalSec = 60
 	}
+	if cfg.Supervision.ManagerBackoffMaxSec == 0 {
+		cfg.Supervision.ManagerBackoffMaxSec = 300
+	}
+	if cfg.Supervision.ManagerCrashLoopRestarts == 0 {
+		cfg.Supervision.ManagerCrashLoopRestarts = 5
+	}
+	if cfg.Mattermost.CallbackURL == "" {
+		cfg.Mattermost.CallbackURL = "http://harness:8090"
+	}
+	if cfg.Mattermost.UploadThreshold == 0 {
+		cfg.Mattermost.UploadThreshold = 4000
+	}
+	if cfg.Access.UserHeader == "" {
+		cfg.Access.UserHeader = "X-Forwarded-User"
+	}
+	if cfg.Access.GroupsHeader == "" {
+		cfg.Access.GroupsHeader = "X-Forwarded-Groups"
+	}
+	if err := validatePolicy(cfg.Policy); err != nil {
+		return nil, err
+	}
+	if cfg.Egress.ProxyPort == 0 {
+		cfg.Egress.ProxyPort = 3128
+	}
 	if cfg.Alerts.GPUUtilizationPercent == 0 {
 		cfg.Alerts.GPUUtilizationPercent = 95
 	}
@@ -43,3 +72,46 @@ func LoadConfig(path string) (*Config, error) {
 	}
 	return &cfg, nil
 }
+
+// FindProject returns the project with the given alias, or nil
+func (c *Config) FindProject(alias string) *Project {
+	for i := range c.Projects {
+		if c.Projects[i].Alias == alias {
+			return &c.Projects[i]
+		}
+	}
+	return nil
+}
+
+// HostPath returns the project path with a leading ~ expanded to HostHome
+func (p Project) HostPath() string {
+	return ExpandHome(p.Path)
+}
+
+// ExpandHome expands a leading ~ in a workspace.yaml path to HostHome
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	return filepath.Join(HostHome(), strings.TrimPrefix(path, "~"))
+}
+
+// HostHome returns the director's home directory on the Docker host.
+// HOST_HOME is preferred over the harness's own home so bind mounts resolve.
+func HostHome() string {
+	if home := os.Getenv("HOST_HOME"); home != "" {
+		return home
+	}
+	home, _ := os.UserHomeDir()
+	return home
+}
+
+// FindPrivilege returns the catalog entry for a privilege ID, or nil
+func (c *Config) FindPrivilege(id string) *Privilege {
+	for i := range c.Privileges {
+		if c.Privileges[i].ID == id {
+			return &c.Privileges[i]
+		}
+	}
+	return nil
+}
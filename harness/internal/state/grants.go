@@ -0,0 +1,133 @@
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// GrantStatus represents the lifecycle of a privilege grant
+type GrantStatus string
+
+const (
+	GrantActive  GrantStatus = "active"
+	GrantExpired GrantStatus = "expired" // per-task grant whose task ended
+	GrantRevoked GrantStatus = "revoked"
+)
+
+// Grant scopes from the privilege catalog
+const (
+	ScopePerTask   = "per-task"
+	ScopePermanent = "permanent"
+)
+
+// Grant is a ledger entry recording a privilege the director granted.
+// Per-task grants cover every worker on TaskID; permanent grants cover
+// every worker on Project.
+type Grant struct {
+	ID          string      `json:"id"`
+	PrivilegeID string      `json:"privilege_id"` // catalog ID or "allow:<domain>"
+	Scope       string      `json:"scope"`        // per-task | permanent
+	Project     string      `json:"project"`
+	TaskID      string      `json:"task_id,omitempty"`
+	ThreadID    string      `json:"thread_id,omitempty"`
+	WorkerID    string      `json:"worker_id,omitempty"` // worker that asked
+	ApprovalID  string      `json:"approval_id,omitempty"`
+	GrantedBy   string      `json:"granted_by"`
+	Reason      string      `json:"reason,omitempty"`
+	GrantedAt   time.Time   `json:"granted_at"`
+	Status      GrantStatus `json:"status"`
+	EndedAt     time.Time   `json:"ended_at,omitempty"`
+	RevokedBy   string      `json:"revoked_by,omitempty"`
+}
+
+// Covers reports whether an active grant applies to a worker on a task
+func (g *Grant) Covers(project, taskID string) bool {
+	if g.Status != GrantActive {
+		return false
+	}
+	if g.Scope == ScopePermanent {
+		return g.Project == project
+	}
+	return g.TaskID != "" && g.TaskID == taskID
+}
+
+// AddGrant records a copy of a grant in the ledger
+func (s *AppState) AddGrant(g *Grant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *g
+	s.grants[g.ID] = &c
+}
+
+// GetGrant returns a copy of a grant by ID, or nil
+func (s *AppState) GetGrant(id string) *Grant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if g, ok := s.grants[id]; ok {
+		c := *g
+		return &c
+	}
+	return nil
+}
+
+// ListGrants returns copies of the ledger, newest first
+func (s *AppState) ListGrants() []*Grant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*Grant, 0, len(s.grants))
+	for _, g := range s.grants {
+		c := *g
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GrantedAt.After(result[j].GrantedAt)
+	})
+	return result
+}
+
+// ActiveGrants returns copies of the active grants covering a worker on a
+// task
+func (s *AppState) ActiveGrants(project, taskID string) []*Grant {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []*Grant
+	for _, g := range s.grants {
+		if g.Covers(project, taskID) {
+			c := *g
+			result = append(result, &c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GrantedAt.Before(result[j].GrantedAt)
+	})
+	return result
+}
+
+// GrantedPrivileges returns the catalog privilege IDs granted to a worker
+// on a task; domain allowances are realized by the proxy instead
+func (s *AppState) GrantedPrivileges(project, taskID string) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, g := range s.ActiveGrants(project, taskID) {
+		if strings.HasPrefix(g.PrivilegeID, "allow:") || seen[g.PrivilegeID] {
+			continue
+		}
+		seen[g.PrivilegeID] = true
+		ids = append(ids, g.PrivilegeID)
+	}
+	return ids
+}
+
+// UpdateGrant applies fn to a grant under the state lock.
+// Returns false if the grant does not exist.
+func (s *AppState) UpdateGrant(id string, fn func(g *Grant)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[id]
+	if !ok {
+		return false
+	}
+	fn(g)
+	return true
+}
@@ -0,0 +1,59 @@
+package state
+
+import "time"
+
+// Inbound is a Mattermost post, edit, deletion or reaction waiting for the
+// harness to consume it. It stays in the inbox, across restarts, until it
+// is acknowledged.
+type Inbound struct {
+	ID          string    `json:"id"` // the post ID for new posts
+	Kind        string    `json:"kind,omitempty"`
+	PostID      string    `json:"post_id"`
+	ThreadID    string    `json:"thread_id"`
+	ChannelID   string    `json:"channel_id"`
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Text        string    `json:"text"`               // the emoji for a reaction
+	Previous    string    `json:"previous,omitempty"` // text before an edit or deletion
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// AddInbound appends an entry to the inbox. It reports false for an entry
+// already there, e.g. one redelivered after a reconnect.
+func (s *AppState) AddInbound(in *Inbound) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, have := range s.inbox {
+		if have.ID == in.ID {
+			return false
+		}
+	}
+	s.inbox = append(s.inbox, in)
+	return true
+}
+
+// PendingInbound returns copies of the unacknowledged entries, oldest first
+func (s *AppState) PendingInbound() []Inbound {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Inbound, len(s.inbox))
+	for i, in := range s.inbox {
+		out[i] = *in
+	}
+	return out
+}
+
+// RemoveInbound drops an acknowledged entry from the inbox and returns
+// it, or nil if it was not there
+func (s *AppState) RemoveInbound(id string) *Inbound {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, in := range s.inbox {
+		if in.ID == id {
+			s.inbox = append(s.inbox[:i], s.inbox[i+1:]...)
+			return in
+		}
+	}
+	return nil
+}
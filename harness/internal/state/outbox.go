@@ -0,0 +1,115 @@
+package state
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// OutboundStatus is the delivery state of a queued Mattermost post
+type OutboundStatus string
+
+const (
+	OutboundPending   OutboundStatus = "pending"
+	OutboundDelivered OutboundStatus = "delivered"
+	OutboundFailed    OutboundStatus = "failed"
+)
+
+// outboxRetention is how long finished posts stay queryable
+const outboxRetention = time.Hour
+
+// Outbound is a post waiting in, or recently through, the Mattermost
+// outbox. Long messages are split into parts posted in order.
+type Outbound struct {
+	ID          string                 `json:"id"`
+	ChannelID   string                 `json:"channel_id,omitempty"` // resolved at send time when empty
+	RootID      string                 `json:"root_id,omitempty"`
+	Parts       []string               `json:"parts"`
+	Props       map[string]interface{} `json:"props,omitempty"`
+	FileIDs     []string               `json:"file_ids,omitempty"`
+	Files       []OutboundFile         `json:"files,omitempty"`    // uploaded before the first part
+	Fallback    []string               `json:"fallback,omitempty"` // parts posted instead if the files cannot be uploaded
+	Sent        int                    `json:"sent"`               // parts already posted
+	PostIDs     []string               `json:"post_ids,omitempty"`
+	Status      OutboundStatus         `json:"status"`
+	Attempts    int                    `json:"attempts"`
+	NextAttempt time.Time              `json:"next_attempt"`
+	LastError   string                 `json:"last_error,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	FinishedAt  time.Time              `json:"finished_at,omitempty"`
+}
+
+// OutboundFile is an attachment spooled to disk until it is uploaded
+type OutboundFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	ID   string `json:"id,omitempty"` // Mattermost file ID once uploaded
+}
+
+// Key groups posts that must be delivered in order: replies by thread,
+// top-level posts by channel
+func (o *Outbound) Key() string {
+	if o.RootID != "" {
+		return o.RootID
+	}
+	return "channel:" + o.ChannelID
+}
+
+// OutboxDir is where attachments of queued posts are spooled, beside the
+// state file so they survive restarts with it
+func (s *AppState) OutboxDir() string {
+	return filepath.Join(filepath.Dir(s.statePath), "outbox")
+}
+
+// Enqueue adds a post to the end of the outbox
+func (s *AppState) Enqueue(o *Outbound) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox = append(s.outbox, o)
+}
+
+// PendingOutbound returns copies of the undelivered posts, oldest first
+func (s *AppState) PendingOutbound() []Outbound {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Outbound
+	for _, o := range s.outbox {
+		if o.Status == OutboundPending {
+			out = append(out, *o)
+		}
+	}
+	return out
+}
+
+// GetOutbound returns a copy of a queued post, or nil once it has aged out
+func (s *AppState) GetOutbound(id string) *Outbound {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, o := range s.outbox {
+		if o.ID == id {
+			copied := *o
+			return &copied
+		}
+	}
+	return nil
+}
+
+// UpdateOutbound modifies a queued post and drops posts that finished
+// longer ago than the retention period
+func (s *AppState) UpdateOutbound(id string, fn func(o *Outbound)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	kept := s.outbox[:0]
+	for _, o := range s.outbox {
+		if o.ID == id {
+			fn(o)
+			found = true
+		}
+		if o.Status != OutboundPending && time.Since(o.FinishedAt) > outboxRetention {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	s.outbox = kept
+	return found
+}
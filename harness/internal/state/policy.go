@@ -0,0 +1,109 @@
+package state
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// MatchPolicy returns the first policy rule matching a privilege request
+// and its label, or nil when no rule applies
+func (c *Config) MatchPolicy(project, workerType, privilegeID string, now time.Time) (*PolicyRule, string) {
+	for i := range c.Policy {
+		if c.Policy[i].Matches(project, workerType, privilegeID, now) {
+			return &c.Policy[i], c.Policy[i].Label(i)
+		}
+	}
+	return nil, ""
+}
+
+// Matches reports whether a rule applies to a privilege request. Hours are
+// compared in now's time zone, the harness's local time.
+func (r *PolicyRule) Matches(project, workerType, privilegeID string, now time.Time) bool {
+	if !matchAny(r.Projects, project) || !matchAny(r.WorkerTypes, workerType) {
+		return false
+	}
+	if len(r.Privileges) > 0 {
+		ok := false
+		for _, pattern := range r.Privileges {
+			if m, _ := path.Match(pattern, privilegeID); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if r.Hours != "" {
+		from, to, err := parseHours(r.Hours)
+		if err != nil {
+			return false
+		}
+		minute := now.Hour()*60 + now.Minute()
+		if from <= to {
+			return minute >= from && minute < to
+		}
+		return minute >= from || minute < to // wraps midnight
+	}
+	return true
+}
+
+// Label identifies a rule in thread posts and the audit trail
+func (r *PolicyRule) Label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule %d", index+1)
+}
+
+func matchAny(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHours reads "HH:MM-HH:MM" into minutes after midnight. 24:00 is
+// accepted as an end time only, for a range running to midnight. Equal
+// bounds are rejected as ambiguous; "00:00-24:00" is all day.
+func parseHours(spec string) (int, int, error) {
+	var fh, fm, th, tm int
+	if _, err := fmt.Sscanf(spec, "%d:%d-%d:%d", &fh, &fm, &th, &tm); err != nil {
+		return 0, 0, fmt.Errorf("hours %q: want HH:MM-HH:MM", spec)
+	}
+	if fh < 0 || fm < 0 || th < 0 || tm < 0 || fh > 23 || fm > 59 || tm > 59 || th > 24 || (th == 24 && tm != 0) {
+		return 0, 0, fmt.Errorf("hours %q: out of range", spec)
+	}
+	if fh*60+fm == th*60+tm {
+		return 0, 0, fmt.Errorf("hours %q: empty window; use 00:00-24:00 for all day", spec)
+	}
+	return fh*60 + fm, th*60 + tm, nil
+}
+
+// validatePolicy rejects rules the engine could not apply
+func validatePolicy(rules []PolicyRule) error {
+	for i, r := range rules {
+		switch r.Action {
+		case PolicyApprove, PolicyDeny, PolicyEscalate:
+		default:
+			return fmt.Errorf("policy %s: action %q must be approve, deny or escalate", r.Label(i), r.Action)
+		}
+		if r.Hours != "" {
+			if _, _, err := parseHours(r.Hours); err != nil {
+				return fmt.Errorf("policy %s: %w", r.Label(i), err)
+			}
+		}
+		for _, pattern := range r.Privileges {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("policy %s: privilege pattern %q: %w", r.Label(i), pattern, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		spec     string
+		from, to int
+		wantErr  bool
+	}{
+		{spec: "08:00-18:00", from: 8 * 60, to: 18 * 60},
+		{spec: "20:00-07:30", from: 20 * 60, to: 7*60 + 30},
+		{spec: "22:00-24:00", from: 22 * 60, to: 24 * 60},
+		{spec: "00:00-24:00", from: 0, to: 24 * 60},
+		{spec: "24:00-08:00", wantErr: true},
+		{spec: "22:00-24:01", wantErr: true},
+		{spec: "22:00-25:00", wantErr: true},
+		{spec: "08:60-18:00", wantErr: true},
+		{spec: "-1:00-18:00", wantErr: true},
+		{spec: "00:00-00:00", wantErr: true},
+		{spec: "09:30-09:30", wantErr: true},
+		{spec: "8am-6pm", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		from, to, err := parseHours(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHours(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (from != tt.from || to != tt.to) {
+			t.Errorf("parseHours(%q) = %d, %d, want %d, %d", tt.spec, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestPolicyRuleMatchesHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 2, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		hours string
+		now   time.Time
+		want  bool
+	}{
+		{"08:00-18:00", at(8, 0), true},
+		{"08:00-18:00", at(12, 30), true},
+		{"08:00-18:00", at(17, 59), true},
+		{"08:00-18:00", at(18, 0), false},
+		{"08:00-18:00", at(7, 59), false},
+
+		{"20:00-07:00", at(20, 0), true},
+		{"20:00-07:00", at(23, 59), true},
+		{"20:00-07:00", at(0, 0), true},
+		{"20:00-07:00", at(6, 59), true},
+		{"20:00-07:00", at(7, 0), false},
+		{"20:00-07:00", at(12, 0), false},
+
+		{"22:00-24:00", at(22, 0), true},
+		{"22:00-24:00", at(23, 59), true},
+		{"22:00-24:00", at(0, 0), false},
+		{"00:00-24:00", at(0, 0), true},
+		{"00:00-24:00", at(23, 59), true},
+
+		// Rejected windows never match
+		{"00:00-00:00", at(0, 0), false},
+		{"22:00-24:01", at(23, 0), false},
+		{"22:00-25:00", at(23, 0), false},
+
+		{"", at(3, 0), true},
+	}
+	for _, tt := range tests {
+		r := PolicyRule{Hours: tt.hours, Action: PolicyApprove}
+		if got := r.Matches("api", "claude", "github-token", tt.now); got != tt.want {
+			t.Errorf("hours %q at %s: Matches = %v, want %v", tt.hours, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestValidatePolicyHours(t *testing.T) {
+	tests := []struct {
+		hours   string
+		wantErr bool
+	}{
+		{"08:00-18:00", false},
+		{"22:00-24:00", false},
+		{"", false},
+		{"00:00-00:00", true},
+		{"22:00-24:01", true},
+		{"25:00-06:00", true},
+	}
+	for _, tt := range tests {
+		err := validatePolicy([]PolicyRule{{Name: "r", Hours: tt.hours, Action: PolicyApprove}})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePolicy(hours %q) error = %v, want error %v", tt.hours, err, tt.wantErr)
+		}
+	}
+}
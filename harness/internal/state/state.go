@@ -3,6 +3,9 @@ package state
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
@@ -12,10 +15,13 @@ type Config struct {
 	Name     string    `yaml:"name" json:"name"`
 	Projects []Project `yaml:"projects" json:"projects"`
 	Privileges []Privilege `yaml:"privileges" json:"privileges"`
+	Policy     []PolicyRule `yaml:"policy" json:"policy"`
 	Models   Models    `yaml:"models" json:"models"`
 	LiteLLM  LiteLLM  `yaml:"litellm" json:"litellm"`
 	Mattermost MattermostConfig `yaml:"mattermost" json:"mattermost"`
+	Access     Access           `yaml:"access" json:"access"`
 	Supervision Supervision `yaml:"supervision" json:"supervision"`
+	Egress   Egress    `yaml:"egress" json:"egress"`
 	Alerts   Alerts    `yaml:"alerts" json:"alerts"`
 }
 
@@ -32,6 +38,31 @@ type Privilege struct {
 	TokenEnv    string `yaml:"token_env,omitempty" json:"token_env,omitempty"`
 	Mount       string `yaml:"mount,omitempty" json:"mount,omitempty"`
 	Mode        string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Network is a Docker network the worker is attached to while granted
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+}
+
+// Policy actions for privilege requests
+const (
+	PolicyApprove  = "approve"
+	PolicyDeny     = "deny"
+	PolicyEscalate = "escalate" // ask the director
+)
+
+// PolicyRule decides privilege requests without the director. Empty match
+// fields match anything; list fields match any entry, and privileges may
+// use globs such as "allow:*.golang.org". The first matching rule wins.
+type PolicyRule struct {
+	Name        string   `yaml:"name" json:"name"`
+	Projects    []string `yaml:"projects,omitempty" json:"projects,omitempty"`
+	WorkerTypes []string `yaml:"worker_types,omitempty" json:"worker_types,omitempty"`
+	Privileges  []string `yaml:"privileges,omitempty" json:"privileges,omitempty"`
+	// Hours limits the rule to a time window such as "08:00-18:00" in the
+	// harness's local time zone, which is UTC in the container unless TZ is
+	// set. Windows may wrap midnight; "00:00-24:00" is all day.
+	Hours  string `yaml:"hours,omitempty" json:"hours,omitempty"`
+	Action string `yaml:"action" json:"action"` // approve | deny | escalate
+	Reason string `yaml:"reason,omitempty" json:"reason,omitempty"`
 }
 
 type Models struct {
@@ -46,15 +77,38 @@ type LiteLLM struct {
 	FallbackModels  []string `yaml:"fallback_models" json:"fallback_models"`
 }
 
+// Egress configures the harness's allowlisting HTTP(S) proxy for workers
+type Egress struct {
+	ProxyPort int `yaml:"proxy_port" json:"proxy_port"`
+	// Allow lists domains every task may reach; "*.example.com" also
+	// matches subdomains. Entries reach ports 80 and 443; "host:port"
+	// allows another port.
+	Allow []string `yaml:"allow" json:"allow"`
+}
+
 type MattermostConfig struct {
 	Channel string `yaml:"channel" json:"channel"`
+	// Team narrows the channel lookup when the bot is in several teams
+	Team string `yaml:"team,omitempty" json:"team,omitempty"`
+	// CallbackURL is the harness web server as Mattermost reaches it, for
+	// interactive buttons
+	CallbackURL string `yaml:"callback_url" json:"callback_url"`
+	// UploadThreshold is the message length above which the text is
+	// attached as a file instead of posted inline
+	UploadThreshold int `yaml:"upload_threshold" json:"upload_threshold"`
 }
 
 type Supervision struct {
 	StuckTimeoutMinutes       int `yaml:"stuck_timeout_minutes" json:"stuck_timeout_minutes"`
 	MaxSpawnRetries           int `yaml:"max_spawn_retries" json:"max_spawn_retries"`
 	TokenBudgetPerTask        int `yaml:"token_budget_per_task" json:"token_budget_per_task"`
+	// TokenWarnFraction of the budget triggers a warning in the task thread
+	TokenWarnFraction         float64 `yaml:"token_warn_fraction" json:"token_warn_fraction"`
 	ResourceSampleIntervalSec int `yaml:"resource_sample_interval_seconds" json:"resource_sample_interval_seconds"`
+	// ManagerBackoffMaxSec caps the delay between manager restarts
+	ManagerBackoffMaxSec int `yaml:"manager_backoff_max_seconds" json:"manager_backoff_max_seconds"`
+	// ManagerCrashLoopRestarts quick exits in a row mark the manager degraded
+	ManagerCrashLoopRestarts int `yaml:"manager_crash_loop_restarts" json:"manager_crash_loop_restarts"`
 }
 
 type Alerts struct {
@@ -73,6 +127,7 @@ const (
 	WorkerHijacked WorkerStatus = "hijacked"
 	WorkerDone     WorkerStatus = "completed"
 	WorkerFailed   WorkerStatus = "failed"
+	WorkerPaused   WorkerStatus = "paused"
 )
 
 // Worker tracks a running worker container
@@ -89,6 +144,115 @@ type Worker struct {
 	SpawnCount   int          `json:"spawn_count"`
 	WorktreePath string       `json:"worktree_path"`
 	Privileges   []string     `json:"privileges"`
+	TaskID       string       `json:"task_id"`
+	Prompt       string       `json:"prompt"`
+	Egress       EgressState  `json:"egress,omitempty"`
+}
+
+// EgressState tracks a worker's attachment to an egress-capable network
+type EgressState string
+
+const (
+	EgressNone      EgressState = ""
+	EgressAttaching EgressState = "attaching"
+	EgressAttached  EgressState = "attached"
+	EgressDetaching EgressState = "detaching"
+)
+
+func (w *Worker) clone() *Worker {
+	c := *w
+	c.Privileges = slices.Clone(w.Privileges)
+	return &c
+}
+
+// IsActive reports whether the worker's container is expected to be running
+func (w *Worker) IsActive() bool {
+	return w.Status == WorkerRunning || w.Status == WorkerHijacked || w.Status == WorkerStuck || w.Status == WorkerPaused
+}
+
+// TaskStatus represents the lifecycle of a task
+type TaskStatus string
+
+const (
+	TaskActive   TaskStatus = "active"
+	TaskDone     TaskStatus = "done"
+	TaskArchived TaskStatus = "archived"
+)
+
+// Patch review states for copy isolation
+const (
+	PatchPending   = "pending"
+	PatchApplied   = "applied"
+	PatchDiscarded = "discarded"
+)
+
+// Task is a unit of work on a project, usually tied to a Mattermost thread.
+// Workers spawned for the same task share its isolated workspace.
+type Task struct {
+	ID           string     `json:"id"`
+	Project      string     `json:"project"`
+	ThreadID     string     `json:"thread_id"`
+	Status       TaskStatus `json:"status"`
+	Isolation    string     `json:"isolation"`
+	Branch       string     `json:"branch,omitempty"`
+	WorktreePath string     `json:"worktree_path"`
+	// BaseCommit is the commit the task's workspace started from. It is
+	// kept here, outside the worker-writable repository, for diffing.
+	BaseCommit string `json:"base_commit,omitempty"`
+	// Patch is the reviewable changeset for copy isolation, and PatchStatus
+	// tracks whether the director has applied or discarded it
+	Patch        string     `json:"patch,omitempty"`
+	PatchStatus  string     `json:"patch_status,omitempty"`
+	// TokenCount sums usage across every worker spawned for the task.
+	// TokenBudget starts at supervision.token_budget_per_task and grows as
+	// the director approves extensions.
+	TokenCount   int64      `json:"token_count"`
+	TokenBudget  int64      `json:"token_budget"`
+	BudgetWarned bool       `json:"budget_warned,omitempty"`
+	// AllowedDomains extends egress.allow for this task's workers
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  time.Time  `json:"completed_at,omitempty"`
+}
+
+func (t *Task) clone() *Task {
+	c := *t
+	c.AllowedDomains = slices.Clone(t.AllowedDomains)
+	return &c
+}
+
+// ApprovalStatus represents the director's decision on an approval request
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalDenied   ApprovalStatus = "denied"
+)
+
+// Approval is a decision the harness is waiting on the director for,
+// asked in a task's Mattermost thread
+type Approval struct {
+	ID        string         `json:"id"`
+	Kind      string         `json:"kind"` // e.g. budget
+	ThreadID  string         `json:"thread_id"`
+	TaskID    string         `json:"task_id,omitempty"`
+	WorkerID  string         `json:"worker_id,omitempty"`
+	Subject   string         `json:"subject"`
+	Detail    string         `json:"detail,omitempty"`
+	Status    ApprovalStatus `json:"status"`
+	DecidedBy string         `json:"decided_by,omitempty"`
+	// Policy names the workspace.yaml rule that decided automatically
+	Policy    string         `json:"policy,omitempty"`
+	Amount    int64          `json:"amount,omitempty"` // e.g. tokens granted
+	CreatedAt time.Time      `json:"created_at"`
+	DecidedAt time.Time      `json:"decided_at,omitempty"`
+	// Prompt and PostID locate the Mattermost post that asked, so it can be
+	// updated with the decision; ActionToken authenticates its buttons. The
+	// token is persisted apart from the approval so no API can serve it.
+	Prompt      string `json:"prompt,omitempty"`
+	PostID      string `json:"post_id,omitempty"`
+	ActionToken string `json:"-"`
 }
 
 // TrafficLight represents API usage status
@@ -113,15 +277,64 @@ type ResourceSnapshot struct {
 	DiskTotalGB  float64   `json:"disk_total_gb"`
 }
 
+// EgressRequest is one request a worker made through the egress proxy
+type EgressRequest struct {
+	Time     time.Time `json:"time"`
+	WorkerID string    `json:"worker_id"`
+	TaskID   string    `json:"task_id"`
+	Method   string    `json:"method"`
+	Host     string    `json:"host"`
+	URL      string    `json:"url,omitempty"` // plain HTTP only; HTTPS is tunneled
+	Allowed  bool      `json:"allowed"`
+}
+
+// ManagerStats is what the harness has seen of the manager agent's
+// stream-json output
+type ManagerStats struct {
+	SessionID    string    `json:"session_id,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Tokens       int64     `json:"tokens"` // billable tokens, all sessions
+	Turns        int       `json:"turns"`  // completed turns
+	ToolCalls    int       `json:"tool_calls"`
+	CostUSD      float64   `json:"cost_usd"`
+	LastTool     string    `json:"last_tool,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastActivity time.Time `json:"last_activity,omitempty"`
+}
+
+// ManagerHealth tracks manager process restarts. The manager is degraded
+// while it keeps exiting soon after starting.
+type ManagerHealth struct {
+	Degraded    bool      `json:"degraded"`
+	Crashes     int       `json:"crashes"` // quick exits in a row
+	LastExit    time.Time `json:"last_exit,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextRestart time.Time `json:"next_restart,omitempty"`
+	StderrTail  []string  `json:"stderr_tail,omitempty"`
+	// AnnounceError is why the last health notice could not be posted to
+	// Mattermost, so the dashboards show what the channel missed
+	AnnounceError string `json:"announce_error,omitempty"`
+}
+
 // AppState is the shared state for the harness
 type AppState struct {
 	mu        sync.RWMutex
 	config    *Config
 	workers   map[string]*Worker
+	tasks     map[string]*Task
+	approvals map[string]*Approval
+	grants    map[string]*Grant
+	threads   map[string]*Thread // thread root ID -> channel and tasks
+	outbox    []*Outbound        // Mattermost posts, oldest first
+	inbox     []*Inbound         // unacknowledged Mattermost posts, oldest first
 	managerPID int
+	manager    ManagerStats
+	managerHealth ManagerHealth
 	trafficLight TrafficLight
 	resources  []ResourceSnapshot // rolling 24h window
+	egressLog  []EgressRequest    // most recent proxy requests
 	statePath  string
+	saveMu     sync.Mutex // serializes writes of the state file
 }
 
 // New creates a new AppState from config
@@ -129,6 +342,10 @@ func New(cfg *Config) *AppState {
 	return &AppState{
 		config:       cfg,
 		workers:      make(map[string]*Worker),
+		tasks:        make(map[string]*Task),
+		approvals:    make(map[string]*Approval),
+		grants:       make(map[string]*Grant),
+		threads:      make(map[string]*Thread),
 		trafficLight: TrafficGreen,
 		resources:    make([]ResourceSnapshot, 0, 1440), // 24h at 1-min intervals
 		statePath:    "workspace-state.json",
@@ -142,31 +359,48 @@ func (s *AppState) Config() *Config {
 	return s.config
 }
 
-// AddWorker registers a new worker
+// AddWorker registers a copy of a new worker
 func (s *AppState) AddWorker(w *Worker) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.workers[w.ID] = w
+	s.workers[w.ID] = w.clone()
 }
 
-// GetWorker returns a worker by ID
+// GetWorker returns a copy of a worker by ID, or nil. Changes go through
+// UpdateWorker.
 func (s *AppState) GetWorker(id string) *Worker {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.workers[id]
+	if w, ok := s.workers[id]; ok {
+		return w.clone()
+	}
+	return nil
 }
 
-// ListWorkers returns all workers
+// ListWorkers returns copies of all workers
 func (s *AppState) ListWorkers() []*Worker {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	result := make([]*Worker, 0, len(s.workers))
 	for _, w := range s.workers {
-		result = append(result, w)
+		result = append(result, w.clone())
 	}
 	return result
 }
 
+// UpdateWorker applies fn to a worker under the state lock.
+// Returns false if the worker does not exist.
+func (s *AppState) UpdateWorker(id string, fn func(w *Worker)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.workers[id]
+	if !ok {
+		return false
+	}
+	fn(w)
+	return true
+}
+
 // RemoveWorker removes a worker by ID
 func (s *AppState) RemoveWorker(id string) {
 	s.mu.Lock()
@@ -174,6 +408,163 @@ func (s *AppState) RemoveWorker(id string) {
 	delete(s.workers, id)
 }
 
+// AddTask registers a copy of a new task
+func (s *AppState) AddTask(t *Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.ID] = t.clone()
+	s.linkThreadTask(t)
+}
+
+// GetTask returns a copy of a task by ID, or nil. Changes go through
+// UpdateTask.
+func (s *AppState) GetTask(id string) *Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.tasks[id]; ok {
+		return t.clone()
+	}
+	return nil
+}
+
+// ListTasks returns copies of all tasks
+func (s *AppState) ListTasks() []*Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		result = append(result, t.clone())
+	}
+	return result
+}
+
+// ActiveTaskForThread returns a copy of the active task for a project in a
+// thread, or nil. An empty project matches any project.
+func (s *AppState) ActiveTaskForThread(threadID, project string) *Task {
+	if threadID == "" {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tasks {
+		if t.ThreadID == threadID && (project == "" || t.Project == project) && t.Status == TaskActive {
+			return t.clone()
+		}
+	}
+	return nil
+}
+
+// UpdateTask applies fn to a task under the state lock.
+// Returns false if the task does not exist.
+func (s *AppState) UpdateTask(id string, fn func(t *Task)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return false
+	}
+	fn(t)
+	return true
+}
+
+// AddTokens records token usage against a worker and its task, returning
+// the task's new total (0 if the worker has no task)
+func (s *AppState) AddTokens(workerID string, n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.workers[workerID]
+	if !ok {
+		return 0
+	}
+	w.TokenCount += n
+	t, ok := s.tasks[w.TaskID]
+	if !ok {
+		return 0
+	}
+	t.TokenCount += n
+	return t.TokenCount
+}
+
+// WorkersForTask returns copies of the workers spawned for a task
+func (s *AppState) WorkersForTask(taskID string) []*Worker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []*Worker
+	for _, w := range s.workers {
+		if w.TaskID == taskID {
+			result = append(result, w.clone())
+		}
+	}
+	return result
+}
+
+// AddApproval registers a copy of a pending approval
+func (s *AppState) AddApproval(a *Approval) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *a
+	s.approvals[a.ID] = &c
+}
+
+// GetApproval returns a copy of an approval by ID, or nil. Changes go
+// through UpdateApproval.
+func (s *AppState) GetApproval(id string) *Approval {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.approvals[id]; ok {
+		c := *a
+		return &c
+	}
+	return nil
+}
+
+// PendingApprovals returns copies of undecided approvals, limited to a
+// thread when threadID is non-empty, oldest first
+func (s *AppState) PendingApprovals(threadID string) []*Approval {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []*Approval
+	for _, a := range s.approvals {
+		if a.Status == ApprovalPending && (threadID == "" || a.ThreadID == threadID) {
+			c := *a
+			result = append(result, &c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// ListApprovals returns copies of every approval, pending or decided,
+// newest first
+func (s *AppState) ListApprovals() []*Approval {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*Approval, 0, len(s.approvals))
+	for _, a := range s.approvals {
+		c := *a
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// UpdateApproval applies fn to an approval under the state lock.
+// Returns false if the approval does not exist.
+func (s *AppState) UpdateApproval(id string, fn func(a *Approval)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.approvals[id]
+	if !ok {
+		return false
+	}
+	fn(a)
+	return true
+}
+
 // SetManagerPID records the manager process ID
 func (s *AppState) SetManagerPID(pid int) {
 	s.mu.Lock()
@@ -188,6 +579,34 @@ func (s *AppState) ManagerPID() int {
 	return s.managerPID
 }
 
+// UpdateManagerStats applies fn to the manager agent's stats
+func (s *AppState) UpdateManagerStats(fn func(*ManagerStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.manager)
+}
+
+// ManagerStats returns a copy of the manager agent's stats
+func (s *AppState) ManagerStats() ManagerStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.manager
+}
+
+// SetManagerHealth records the manager's restart state
+func (s *AppState) SetManagerHealth(h ManagerHealth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.managerHealth = h
+}
+
+// ManagerHealth returns the manager's restart state
+func (s *AppState) ManagerHealth() ManagerHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.managerHealth
+}
+
 // SetTrafficLight updates the API usage status
 func (s *AppState) SetTrafficLight(tl TrafficLight) {
 	s.mu.Lock()
@@ -224,27 +643,116 @@ func (s *AppState) LatestResource() *ResourceSnapshot {
 	return &snap
 }
 
+// maxEgressLog bounds the in-memory proxy request log; the harness log
+// keeps the full history
+const maxEgressLog = 1000
+
+// RecordEgress appends a proxy request to the egress log
+func (s *AppState) RecordEgress(r EgressRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.egressLog = append(s.egressLog, r)
+	if len(s.egressLog) > maxEgressLog {
+		s.egressLog = s.egressLog[len(s.egressLog)-maxEgressLog:]
+	}
+}
+
+// EgressLog returns logged proxy requests, newest first, optionally
+// filtered to one worker
+func (s *AppState) EgressLog(workerID string) []EgressRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []EgressRequest
+	for i := len(s.egressLog) - 1; i >= 0; i-- {
+		if workerID == "" || s.egressLog[i].WorkerID == workerID {
+			out = append(out, s.egressLog[i])
+		}
+	}
+	return out
+}
+
 // persistedState is the JSON-serializable form of AppState
 type persistedState struct {
 	Workers      map[string]*Worker `json:"workers"`
+	Tasks        map[string]*Task   `json:"tasks"`
+	Approvals    map[string]*Approval `json:"approvals"`
+	Grants       map[string]*Grant    `json:"grants"`
+	Threads      map[string]*Thread   `json:"threads"`
+	Outbox       []*Outbound          `json:"outbox,omitempty"`
+	Inbox        []*Inbound           `json:"inbox,omitempty"`
+	ActionTokens map[string]string    `json:"action_tokens,omitempty"` // approval ID -> button token
 	ManagerPID   int                `json:"manager_pid"`
+	Manager      ManagerStats       `json:"manager"`
 	TrafficLight TrafficLight       `json:"traffic_light"`
 }
 
-// Save persists state to disk
+// Save persists state to disk. The file is replaced atomically, so a crash
+// mid-save leaves the previous state intact.
 func (s *AppState) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	data, err := s.marshal()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.statePath, data, 0644)
+}
+
+func (s *AppState) marshal() ([]byte, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	ps := persistedState{
 		Workers:      s.workers,
+		Tasks:        s.tasks,
+		Approvals:    s.approvals,
+		Grants:       s.grants,
+		Threads:      s.threads,
+		Outbox:       s.outbox,
+		Inbox:        s.inbox,
+		ActionTokens: make(map[string]string),
 		ManagerPID:   s.managerPID,
+		Manager:      s.manager,
 		TrafficLight: s.trafficLight,
 	}
-	data, err := json.MarshalIndent(ps, "", "  ")
+	for id, a := range s.approvals {
+		if a.ActionToken != "" && a.Status == ApprovalPending {
+			ps.ActionTokens[id] = a.ActionToken
+		}
+	}
+	return json.MarshalIndent(ps, "", "  ")
+}
+
+// writeFileAtomic writes data to a temporary file beside path, syncs it and
+// renames it over path
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.statePath, data, 0644)
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 // Recover loads state from disk
@@ -263,7 +771,43 @@ func (s *AppState) Recover() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.workers = ps.Workers
+	if s.workers == nil {
+		s.workers = make(map[string]*Worker)
+	}
+	s.tasks = ps.Tasks
+	if s.tasks == nil {
+		s.tasks = make(map[string]*Task)
+	}
+	s.approvals = ps.Approvals
+	if s.approvals == nil {
+		s.approvals = make(map[string]*Approval)
+	}
+	for id, token := range ps.ActionTokens {
+		if a, ok := s.approvals[id]; ok {
+			a.ActionToken = token
+		}
+	}
+	s.grants = ps.Grants
+	if s.grants == nil {
+		s.grants = make(map[string]*Grant)
+	}
+	s.threads = ps.Threads
+	if s.threads == nil {
+		s.threads = make(map[string]*Thread)
+	}
+	s.outbox = ps.Outbox
+	s.inbox = ps.Inbox
+	for _, in := range s.inbox {
+		if in.ID == "" {
+			in.ID = in.PostID
+		}
+	}
+	// Tasks recorded before the registry existed
+	for _, t := range s.tasks {
+		s.linkThreadTask(t)
+	}
 	s.managerPID = ps.ManagerPID
+	s.manager = ps.Manager
 	s.trafficLight = ps.TrafficLight
 	return nil
 }
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSaveConcurrent(t *testing.T) {
+	t.Chdir(t.TempDir())
+	s := New(&Config{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.AddTask(&Task{ID: fmt.Sprintf("task%d", i)})
+			if err := s.Save(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "workspace-state.json" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("state directory holds %v, want only the state file", names)
+	}
+	data, err := os.ReadFile("workspace-state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(data) {
+		t.Fatalf("state file is not valid JSON:\n%s", data)
+	}
+
+	recovered := New(&Config{})
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recovered.ListTasks()); n != 20 {
+		t.Errorf("recovered %d tasks, want 20", n)
+	}
+}
+
+func TestGettersReturnCopies(t *testing.T) {
+	s := New(&Config{})
+	s.AddWorker(&Worker{ID: "w1", TaskID: "t1", Status: WorkerRunning, Privileges: []string{"gh"}})
+	s.AddTask(&Task{ID: "t1", AllowedDomains: []string{"example.com"}})
+	s.AddApproval(&Approval{ID: "a1", Status: ApprovalPending})
+
+	w := s.GetWorker("w1")
+	w.Status = WorkerFailed
+	w.Privileges[0] = "changed"
+	task := s.GetTask("t1")
+	task.AllowedDomains[0] = "changed"
+	s.PendingApprovals("")[0].Status = ApprovalDenied
+
+	if w := s.GetWorker("w1"); w.Status != WorkerRunning || w.Privileges[0] != "gh" {
+		t.Errorf("worker changed through a copy: %+v", w)
+	}
+	if task := s.GetTask("t1"); task.AllowedDomains[0] != "example.com" {
+		t.Errorf("task changed through a copy: %+v", task)
+	}
+	if a := s.GetApproval("a1"); a.Status != ApprovalPending {
+		t.Errorf("approval changed through a copy: %+v", a)
+	}
+
+	// Readers hold their copies while the state changes underneath
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.UpdateWorker("w1", func(w *Worker) { w.TokenCount++ })
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, w := range s.WorkersForTask("t1") {
+				_ = w.TokenCount
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package state
+
+import "time"
+
+// Thread records where a Mattermost thread lives so replies can be posted
+// without callers knowing its channel, and which tasks it started
+type Thread struct {
+	ID        string    `json:"id"` // root post ID
+	ChannelID string    `json:"channel_id"`
+	TaskIDs   []string  `json:"task_ids,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RememberThread records a thread's channel. It reports whether the
+// registry changed, so callers only persist new information.
+func (s *AppState) RememberThread(threadID, channelID string) bool {
+	if threadID == "" || channelID == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.thread(threadID)
+	if t.ChannelID == channelID {
+		return false
+	}
+	t.ChannelID = channelID
+	t.UpdatedAt = time.Now()
+	return true
+}
+
+// ThreadChannel returns the channel a thread was seen in, or ""
+func (s *AppState) ThreadChannel(threadID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if t, ok := s.threads[threadID]; ok {
+		return t.ChannelID
+	}
+	return ""
+}
+
+// GetThread returns a thread's registry entry
+func (s *AppState) GetThread(threadID string) *Thread {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.threads[threadID]
+	if !ok {
+		return nil
+	}
+	copied := *t
+	copied.TaskIDs = append([]string(nil), t.TaskIDs...)
+	return &copied
+}
+
+// thread returns the registry entry for a thread, creating it. Callers
+// hold the lock.
+func (s *AppState) thread(threadID string) *Thread {
+	t, ok := s.threads[threadID]
+	if !ok {
+		t = &Thread{ID: threadID}
+		s.threads[threadID] = t
+	}
+	return t
+}
+
+// linkThreadTask adds a task to its thread's entry. Callers hold the lock.
+func (s *AppState) linkThreadTask(task *Task) {
+	if task.ThreadID == "" {
+		return
+	}
+	t := s.thread(task.ThreadID)
+	for _, id := range t.TaskIDs {
+		if id == task.ID {
+			return
+		}
+	}
+	t.TaskIDs = append(t.TaskIDs, task.ID)
+	t.UpdatedAt = time.Now()
+}
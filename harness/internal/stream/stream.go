@@ -0,0 +1,154 @@
+package stream
+
+// This file models Claude Code's stream-json protocol, spoken by both the
+// manager agent and frontier workers
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Event types
+const (
+	TypeSystem    = "system"
+	TypeAssistant = "assistant"
+	TypeUser      = "user"
+	TypeResult    = "result"
+)
+
+// Content block types
+const (
+	BlockText       = "text"
+	BlockToolUse    = "tool_use"
+	BlockToolResult = "tool_result"
+)
+
+// Event is one line of stream-json output
+type Event struct {
+	Type      string   `json:"type"`
+	Subtype   string   `json:"subtype,omitempty"`
+	SessionID string   `json:"session_id,omitempty"`
+	Message   *Message `json:"message,omitempty"`
+
+	// Result events close a turn
+	IsError      bool    `json:"is_error,omitempty"`
+	Result       string  `json:"result,omitempty"`
+	NumTurns     int     `json:"num_turns,omitempty"`
+	DurationMS   int64   `json:"duration_ms,omitempty"`
+	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
+	Usage        *Usage  `json:"usage,omitempty"`
+}
+
+// Message is an assistant or user message carried by an event
+type Message struct {
+	ID      string  `json:"id,omitempty"`
+	Role    string  `json:"role,omitempty"`
+	Model   string  `json:"model,omitempty"`
+	Content []Block `json:"content,omitempty"`
+	Usage   *Usage  `json:"usage,omitempty"`
+}
+
+// Block is a message content block: text, a tool call or a tool result
+type Block struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// Usage is token usage reported on assistant messages and results
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// Billable is the usage counted against budgets. Cache reads are cheap and
+// repeat every turn, so they are left out.
+func (u *Usage) Billable() int64 {
+	if u == nil {
+		return 0
+	}
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens
+}
+
+// Parse decodes a stream-json line. Lines that are not JSON events, such
+// as a local worker's plain output, return false.
+func Parse(line string) (*Event, bool) {
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Type == "" {
+		return nil, false
+	}
+	return &ev, true
+}
+
+// Text returns the lines of an assistant message's text blocks
+func (e *Event) Text() []string {
+	var lines []string
+	for _, text := range e.TextBlocks() {
+		lines = append(lines, strings.Split(text, "\n")...)
+	}
+	return lines
+}
+
+// TextBlocks returns the text of an assistant message's text blocks, whole
+func (e *Event) TextBlocks() []string {
+	var texts []string
+	for _, b := range e.blocks(TypeAssistant, BlockText) {
+		texts = append(texts, b.Text)
+	}
+	return texts
+}
+
+// ToolCalls returns an assistant message's tool_use blocks
+func (e *Event) ToolCalls() []Block {
+	return e.blocks(TypeAssistant, BlockToolUse)
+}
+
+// ToolResults returns the tool_result blocks fed back to the model
+func (e *Event) ToolResults() []Block {
+	return e.blocks(TypeUser, BlockToolResult)
+}
+
+func (e *Event) blocks(eventType, blockType string) []Block {
+	if e.Type != eventType || e.Message == nil {
+		return nil
+	}
+	var blocks []Block
+	for _, b := range e.Message.Content {
+		if b.Type == blockType {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// userTurn is a stream-json input message
+type userTurn struct {
+	Type    string `json:"type"`
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+// UserTurn encodes text as one stream-json input line, newline included
+func UserTurn(text string) []byte {
+	turn := userTurn{Type: TypeUser}
+	turn.Message.Role = "user"
+	turn.Message.Content = text
+	payload, _ := json.Marshal(turn) // strings always marshal
+	return append(payload, '\n')
+}
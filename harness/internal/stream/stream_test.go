@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Lines as written by claude -p --output-format stream-json --verbose
+const (
+	fixtureInit       = `{"type":"system","subtype":"init","cwd":"/workspace","session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34","tools":["Read","Glob","Grep"],"mcp_servers":[],"model":"claude-sonnet-4-20250514","permissionMode":"default","apiKeySource":"none"}`
+	fixtureText       = `{"type":"assistant","message":{"id":"msg_01HqX","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"[MM:k3j9x] Spawning a worker for the fix.\nIt will report back here."}],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":12,"cache_creation_input_tokens":2048,"cache_read_input_tokens":10240,"output_tokens":35,"service_tier":"standard"}},"parent_tool_use_id":null,"session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34"}`
+	fixtureToolUse    = `{"type":"assistant","message":{"id":"msg_01HqY","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"tool_use","id":"toolu_01Abc","name":"Read","input":{"file_path":"/workspace/README.md"}}],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":4,"cache_creation_input_tokens":0,"cache_read_input_tokens":12288,"output_tokens":61,"service_tier":"standard"}},"parent_tool_use_id":null,"session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34"}`
+	fixtureToolResult = `{"type":"user","message":{"role":"user","content":[{"tool_use_id":"toolu_01Abc","type":"tool_result","content":"# workspace\n","is_error":false}]},"parent_tool_use_id":null,"session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34"}`
+	fixtureResult     = `{"type":"result","subtype":"success","is_error":false,"duration_ms":5123,"duration_api_ms":4810,"num_turns":2,"result":"Spawned.","session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34","total_cost_usd":0.0123,"usage":{"input_tokens":16,"cache_creation_input_tokens":2048,"cache_read_input_tokens":22528,"output_tokens":96,"server_tool_use":{"web_search_requests":0},"service_tier":"standard"}}`
+	fixtureError      = `{"type":"result","subtype":"error_max_turns","is_error":true,"duration_ms":60210,"num_turns":30,"session_id":"3f6a1c52-8d7e-4b1a-9c0e-2d5f7a9b1e34","total_cost_usd":0.41}`
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		typ     string
+		subtype string
+		isError bool
+	}{
+		{line: fixtureInit, ok: true, typ: TypeSystem, subtype: "init"},
+		{line: fixtureText, ok: true, typ: TypeAssistant},
+		{line: fixtureToolUse, ok: true, typ: TypeAssistant},
+		{line: fixtureToolResult, ok: true, typ: TypeUser},
+		{line: fixtureResult, ok: true, typ: TypeResult, subtype: "success"},
+		{line: fixtureError, ok: true, typ: TypeResult, subtype: "error_max_turns", isError: true},
+
+		{line: ""},
+		{line: "Running tests..."},
+		{line: "{not json"},
+		{line: `{"message":"no type"}`},
+		{line: `  {"type":"result"}`},
+	}
+	for _, tt := range tests {
+		ev, ok := Parse(tt.line)
+		if ok != tt.ok {
+			t.Errorf("Parse(%.40q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			if ev != nil {
+				t.Errorf("Parse(%.40q) returned an event with ok false", tt.line)
+			}
+			continue
+		}
+		if ev.Type != tt.typ || ev.Subtype != tt.subtype || ev.IsError != tt.isError {
+			t.Errorf("Parse(%.40q) = %s/%s error %v, want %s/%s error %v",
+				tt.line, ev.Type, ev.Subtype, ev.IsError, tt.typ, tt.subtype, tt.isError)
+		}
+	}
+}
+
+func TestEventText(t *testing.T) {
+	twoBlocks := `{"type":"assistant","message":{"id":"msg_2","role":"assistant","content":[{"type":"text","text":"first\nsecond"},{"type":"tool_use","id":"toolu_2","name":"Glob","input":{}},{"type":"text","text":"third"}]}}`
+	tests := []struct {
+		line   string
+		blocks []string
+		lines  []string
+	}{
+		{
+			line:   fixtureText,
+			blocks: []string{"[MM:k3j9x] Spawning a worker for the fix.\nIt will report back here."},
+			lines:  []string{"[MM:k3j9x] Spawning a worker for the fix.", "It will report back here."},
+		},
+		{
+			line:   twoBlocks,
+			blocks: []string{"first\nsecond", "third"},
+			lines:  []string{"first", "second", "third"},
+		},
+		{line: fixtureToolUse},
+		{line: fixtureToolResult},
+		{line: fixtureResult},
+	}
+	for _, tt := range tests {
+		ev, ok := Parse(tt.line)
+		if !ok {
+			t.Fatalf("Parse(%.40q) failed", tt.line)
+		}
+		if got := ev.TextBlocks(); !reflect.DeepEqual(got, tt.blocks) {
+			t.Errorf("TextBlocks(%.40q) = %q, want %q", tt.line, got, tt.blocks)
+		}
+		if got := ev.Text(); !reflect.DeepEqual(got, tt.lines) {
+			t.Errorf("Text(%.40q) = %q, want %q", tt.line, got, tt.lines)
+		}
+	}
+}
+
+func TestToolCallsAndResults(t *testing.T) {
+	ev, _ := Parse(fixtureToolUse)
+	calls := ev.ToolCalls()
+	if len(calls) != 1 || calls[0].Name != "Read" || calls[0].ID != "toolu_01Abc" {
+		t.Errorf("ToolCalls = %+v", calls)
+	}
+	if len(ev.ToolResults()) != 0 {
+		t.Error("assistant event has tool results")
+	}
+
+	ev, _ = Parse(fixtureToolResult)
+	results := ev.ToolResults()
+	if len(results) != 1 || results[0].ToolUseID != "toolu_01Abc" || results[0].IsError {
+		t.Errorf("ToolResults = %+v", results)
+	}
+	if len(ev.ToolCalls()) != 0 {
+		t.Error("user event has tool calls")
+	}
+}
+
+func TestBillable(t *testing.T) {
+	text, _ := Parse(fixtureText)
+	result, _ := Parse(fixtureResult)
+	errored, _ := Parse(fixtureError)
+	tests := []struct {
+		name  string
+		usage *Usage
+		want  int64
+	}{
+		{"assistant message", text.Message.Usage, 12 + 35 + 2048},
+		{"result", result.Usage, 16 + 96 + 2048},
+		{"no usage reported", errored.Usage, 0},
+		{"cache reads only", &Usage{CacheReadInputTokens: 50000}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.usage.Billable(); got != tt.want {
+			t.Errorf("%s: Billable = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserTurn(t *testing.T) {
+	line := UserTurn("line one\n\"quoted\"")
+	if line[len(line)-1] != '\n' {
+		t.Fatal("UserTurn does not end in a newline")
+	}
+	var turn struct {
+		Type    string `json:"type"`
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(line, &turn); err != nil {
+		t.Fatal(err)
+	}
+	if turn.Type != TypeUser || turn.Message.Role != "user" || turn.Message.Content != "line one\n\"quoted\"" {
+		t.Errorf("UserTurn decoded to %+v", turn)
+	}
+}
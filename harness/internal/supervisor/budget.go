@@ -0,0 +1,179 @@
+package supervisor
+
+import (
+	"fmt"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+// KindBudget is the approval kind for token budget extensions
+const KindBudget = "budget"
+
+// HandleOutput accounts token usage reported in worker output and enforces
+// the task budget. It is registered with the spawner as an output handler.
+// Assistant messages may be emitted once per content block with the same
+// usage, so they are de-duplicated by message ID.
+//
+// Budgets meter frontier workers only. Local (OpenCode) workers run on the
+// bundled Ollama, cost nothing per token and report no usage, so they are
+// never counted or paused.
+func (s *Supervisor) HandleOutput(workerID, line string, ev *stream.Event) {
+	if ev == nil || ev.Type != stream.TypeAssistant || ev.Message == nil || ev.Message.ID == "" {
+		return
+	}
+	w := s.state.GetWorker(workerID)
+	if w == nil || w.WorkerType == "local" {
+		return
+	}
+
+	s.mu.Lock()
+	seen := s.lastMessage[workerID] == ev.Message.ID
+	s.lastMessage[workerID] = ev.Message.ID
+	s.mu.Unlock()
+	if seen {
+		return
+	}
+
+	n := ev.Message.Usage.Billable()
+	if n == 0 {
+		return
+	}
+	s.state.AddTokens(workerID, n)
+	s.checkBudget(w.TaskID)
+}
+
+// checkBudget warns once the task crosses the warning fraction and pauses
+// its workers when the budget is spent, pending director approval
+func (s *Supervisor) checkBudget(taskID string) {
+	cfg := s.state.Config().Supervision
+	var warn, exhausted bool
+	var t state.Task
+	s.state.UpdateTask(taskID, func(task *state.Task) {
+		if task.TokenBudget == 0 {
+			task.TokenBudget = int64(cfg.TokenBudgetPerTask)
+		}
+		if task.TokenCount >= task.TokenBudget {
+			exhausted = true
+		} else if !task.BudgetWarned && float64(task.TokenCount) >= cfg.TokenWarnFraction*float64(task.TokenBudget) {
+			task.BudgetWarned = true
+			warn = true
+		}
+		t = *task
+	})
+
+	if warn {
+		s.mm.Notify(t.ThreadID, fmt.Sprintf("Task `%s` has used %d of its %d token budget (%.0f%%).",
+			t.ID, t.TokenCount, t.TokenBudget, 100*float64(t.TokenCount)/float64(t.TokenBudget)))
+	}
+	if exhausted {
+		// Several workers on one task may cross the line together; only ask once
+		s.budgetMu.Lock()
+		defer s.budgetMu.Unlock()
+		if !s.hasPendingBudget(t.ID) {
+			s.exhaustBudget(&t)
+		}
+	}
+}
+
+func (s *Supervisor) hasPendingBudget(taskID string) bool {
+	for _, a := range s.state.PendingApprovals("") {
+		if a.Kind == KindBudget && a.TaskID == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Supervisor) exhaustBudget(t *state.Task) {
+	for _, w := range s.state.WorkersForTask(t.ID) {
+		if w.WorkerType == "local" || (w.Status != state.WorkerRunning && w.Status != state.WorkerStuck) {
+			continue
+		}
+		if err := s.spawner.Pause(w.ID); err != nil {
+			s.logger.Error("Failed to pause worker over budget", "worker", w.ID, "error", err)
+		}
+	}
+	s.logger.Warn("Task token budget exhausted", "task", t.ID, "used", t.TokenCount, "budget", t.TokenBudget)
+
+	extension := s.state.Config().Supervision.TokenBudgetPerTask
+	a := &state.Approval{
+		Kind:     KindBudget,
+		ThreadID: t.ThreadID,
+		TaskID:   t.ID,
+		Subject:  fmt.Sprintf("token budget for task %s", t.ID),
+		Amount:   int64(extension),
+	}
+	prompt := fmt.Sprintf("Task `%s` has used %d tokens, exhausting its budget of %d. Its workers are paused.\n"+
+		"Approve to extend the budget by %d tokens (or `approve +N` for a different amount); deny to stop the workers.",
+		t.ID, t.TokenCount, t.TokenBudget, extension)
+	if err := s.approvals.Request(a, prompt); err != nil {
+		s.logger.Error("Failed to request budget extension", "task", t.ID, "error", err)
+	}
+	s.tellManager(fmt.Sprintf("[Harness] Task %s exhausted its token budget (%d tokens); its workers are paused pending director approval.", t.ID, t.TokenBudget))
+}
+
+// decideBudget applies the director's answer to a budget extension request
+func (s *Supervisor) decideBudget(a *state.Approval) {
+	workers := s.state.WorkersForTask(a.TaskID)
+	if a.Status != state.ApprovalApproved {
+		for _, w := range workers {
+			if !w.IsActive() || w.WorkerType == "local" {
+				continue
+			}
+			if err := s.spawner.Kill(w.ID); err != nil {
+				s.logger.Error("Failed to stop worker over budget", "worker", w.ID, "error", err)
+			}
+		}
+		s.mm.Notify(a.ThreadID, fmt.Sprintf("Budget extension denied by %s; frontier workers on task `%s` stopped.", a.DecidedBy, a.TaskID))
+		s.tellManager(fmt.Sprintf("[Harness] Task %s ran out of token budget and the director declined an extension; its workers were stopped.", a.TaskID))
+		return
+	}
+
+	s.extend(a.TaskID, a.Amount, a.DecidedBy)
+}
+
+// ExtendBudget raises a task's token budget at a director's command. A
+// pending extension request is approved with the amount instead, so the
+// director's answer is recorded in one place.
+func (s *Supervisor) ExtendBudget(taskID string, amount int64, by string) error {
+	if amount <= 0 {
+		return fmt.Errorf("extension must be a positive number of tokens")
+	}
+	if s.state.GetTask(taskID) == nil {
+		return fmt.Errorf("unknown task %q", taskID)
+	}
+	for _, a := range s.state.PendingApprovals("") {
+		if a.Kind == KindBudget && a.TaskID == taskID {
+			return s.approvals.Decide(a.ID, true, by, amount)
+		}
+	}
+	s.extend(taskID, amount, by)
+	return nil
+}
+
+// extend raises a task's budget and resumes workers paused over it
+func (s *Supervisor) extend(taskID string, amount int64, by string) {
+	initial := int64(s.state.Config().Supervision.TokenBudgetPerTask)
+	var t state.Task
+	s.state.UpdateTask(taskID, func(task *state.Task) {
+		if task.TokenBudget == 0 {
+			task.TokenBudget = initial
+		}
+		task.TokenBudget += amount
+		task.BudgetWarned = false
+		t = *task
+	})
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist budget extension", "error", err)
+	}
+	for _, w := range s.state.WorkersForTask(taskID) {
+		if w.Status != state.WorkerPaused {
+			continue
+		}
+		if err := s.spawner.Resume(w.ID); err != nil {
+			s.logger.Error("Failed to resume worker", "worker", w.ID, "error", err)
+		}
+	}
+	s.mm.Notify(t.ThreadID, fmt.Sprintf("Budget for task `%s` extended by %d to %d tokens by %s; paused workers resumed.", taskID, amount, t.TokenBudget, by))
+}
@@ -0,0 +1,184 @@
+package supervisor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/stream"
+)
+
+// fakeDocker puts a docker on PATH that records its arguments and
+// succeeds, and returns a function reading what it was asked to do
+func fakeDocker(t *testing.T) func() []string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func() []string {
+		data, _ := os.ReadFile(calls)
+		if len(data) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+}
+
+func newBudgetSupervisor(t *testing.T) (*Supervisor, *state.AppState) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	cfg := &state.Config{Supervision: state.Supervision{TokenBudgetPerTask: 1000, TokenWarnFraction: 0.8}}
+	st := state.New(cfg)
+	logger := log.New(io.Discard)
+	sp := spawner.New(st, nil, logger)
+	s := New(st, sp, approval.NewBroker(st, nil, logger), nil, logger)
+
+	st.AddTask(&state.Task{ID: "t1", Status: state.TaskActive})
+	st.AddWorker(&state.Worker{ID: "w1", TaskID: "t1", ContainerID: "c1", WorkerType: "frontier", Status: state.WorkerRunning})
+	st.AddWorker(&state.Worker{ID: "w2", TaskID: "t1", ContainerID: "c2", WorkerType: "local", Status: state.WorkerRunning})
+	return s, st
+}
+
+// usage feeds an assistant message reporting n billable tokens
+func usage(t *testing.T, s *Supervisor, workerID, msgID string, n int) {
+	t.Helper()
+	line := fmt.Sprintf(`{"type":"assistant","message":{"id":%q,"role":"assistant","content":[{"type":"text","text":"working"}],"usage":{"input_tokens":%d,"output_tokens":0,"cache_read_input_tokens":9000}}}`, msgID, n)
+	ev, ok := stream.Parse(line)
+	if !ok {
+		t.Fatalf("bad fixture %s", line)
+	}
+	s.HandleOutput(workerID, line, ev)
+}
+
+func pendingBudget(st *state.AppState) []*state.Approval {
+	var result []*state.Approval
+	for _, a := range st.PendingApprovals("") {
+		if a.Kind == KindBudget {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func TestBudgetUnderThreshold(t *testing.T) {
+	docker := fakeDocker(t)
+	s, st := newBudgetSupervisor(t)
+
+	usage(t, s, "w1", "msg_1", 300)
+	usage(t, s, "w1", "msg_1", 300) // the same message once per content block
+	usage(t, s, "w1", "msg_2", 400)
+	usage(t, s, "w2", "msg_3", 5000) // local workers are not metered
+
+	task := st.GetTask("t1")
+	if task.TokenCount != 700 || task.TokenBudget != 1000 {
+		t.Errorf("task used %d of %d, want 700 of 1000", task.TokenCount, task.TokenBudget)
+	}
+	if task.BudgetWarned {
+		t.Error("warned under the warning fraction")
+	}
+	if n := len(pendingBudget(st)); n != 0 {
+		t.Errorf("%d budget requests under the budget", n)
+	}
+	if calls := docker(); len(calls) != 0 {
+		t.Errorf("docker called under the budget: %v", calls)
+	}
+}
+
+func TestBudgetCrossed(t *testing.T) {
+	docker := fakeDocker(t)
+	s, st := newBudgetSupervisor(t)
+
+	usage(t, s, "w1", "msg_1", 850)
+	if task := st.GetTask("t1"); !task.BudgetWarned {
+		t.Error("not warned past the warning fraction")
+	}
+	if n := len(pendingBudget(st)); n != 0 {
+		t.Fatalf("%d budget requests before the budget is spent", n)
+	}
+
+	usage(t, s, "w1", "msg_2", 200)
+	usage(t, s, "w1", "msg_3", 50)
+	pending := pendingBudget(st)
+	if len(pending) != 1 {
+		t.Fatalf("%d budget requests once spent, want 1", len(pending))
+	}
+	if a := pending[0]; a.TaskID != "t1" || a.Amount != 1000 {
+		t.Errorf("budget request %+v", a)
+	}
+	if w := st.GetWorker("w1"); w.Status != state.WorkerPaused {
+		t.Errorf("frontier worker is %s, want paused", w.Status)
+	}
+	if w := st.GetWorker("w2"); w.Status != state.WorkerRunning {
+		t.Errorf("local worker is %s, want running", w.Status)
+	}
+	if calls := docker(); len(calls) != 1 || calls[0] != "pause c1" {
+		t.Errorf("docker calls %v, want one pause of c1", calls)
+	}
+}
+
+func TestBudgetExtended(t *testing.T) {
+	docker := fakeDocker(t)
+	s, st := newBudgetSupervisor(t)
+	usage(t, s, "w1", "msg_1", 1100)
+	if len(pendingBudget(st)) != 1 {
+		t.Fatal("no budget request once spent")
+	}
+
+	if err := s.ExtendBudget("t1", 0, "dana"); err == nil {
+		t.Error("extended by zero tokens")
+	}
+	if err := s.ExtendBudget("nope", 500, "dana"); err == nil {
+		t.Error("extended an unknown task")
+	}
+	if err := s.ExtendBudget("t1", 5000, "dana"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(pendingBudget(st)); n != 0 {
+		t.Errorf("%d budget requests left after the extension", n)
+	}
+	var decided *state.Approval
+	for _, a := range st.ListApprovals() {
+		if a.Kind == KindBudget {
+			decided = a
+		}
+	}
+	if decided == nil || decided.Status != state.ApprovalApproved || decided.DecidedBy != "dana" || decided.Amount != 5000 {
+		t.Errorf("budget request decided as %+v", decided)
+	}
+	task := st.GetTask("t1")
+	if task.TokenBudget != 6000 || task.BudgetWarned {
+		t.Errorf("task budget %d (warned %v), want 6000 and not warned", task.TokenBudget, task.BudgetWarned)
+	}
+	if w := st.GetWorker("w1"); w.Status != state.WorkerRunning {
+		t.Errorf("worker is %s after the extension, want running", w.Status)
+	}
+	if calls := docker(); len(calls) != 2 || calls[1] != "unpause c1" {
+		t.Errorf("docker calls %v, want a pause then an unpause of c1", calls)
+	}
+
+	// Usage under the new budget asks nothing
+	usage(t, s, "w1", "msg_2", 1000)
+	if n := len(pendingBudget(st)); n != 0 {
+		t.Errorf("%d budget requests under the extended budget", n)
+	}
+
+	// Without a pending request the director can still raise the budget
+	if err := s.ExtendBudget("t1", 1000, "dana"); err != nil {
+		t.Fatal(err)
+	}
+	if task := st.GetTask("t1"); task.TokenBudget != 7000 {
+		t.Errorf("task budget %d, want 7000", task.TokenBudget)
+	}
+}
@@ -0,0 +1,51 @@
+package supervisor
+
+import (
+	"fmt"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// KindMerge is the approval kind for merging a copy-isolated task's patch
+// back into its project
+const KindMerge = "merge"
+
+// requestMerge asks the director whether to apply a task's finished patch.
+// It is registered with the spawner as a patch handler.
+func (s *Supervisor) requestMerge(taskID, stat string) {
+	t := s.state.GetTask(taskID)
+	if t == nil {
+		return
+	}
+	a := &state.Approval{
+		Kind:     KindMerge,
+		ThreadID: t.ThreadID,
+		TaskID:   t.ID,
+		Subject:  fmt.Sprintf("merge-back of task %s into %s", t.ID, t.Project),
+	}
+	prompt := fmt.Sprintf("Task `%s` finished. Patch ready for review:\n```\n%s\n```\nApprove to apply it to `%s`; deny to discard it.", t.ID, stat, t.Project)
+	if err := s.approvals.Request(a, prompt); err != nil {
+		s.logger.Error("Failed to request merge-back", "task", t.ID, "error", err)
+	}
+	s.tellManager(fmt.Sprintf("[Harness] Task %s finished with a patch for %s; awaiting director approval to merge it back (request %s).", t.ID, t.Project, a.ID))
+}
+
+// decideMerge applies or discards a task's patch per the director's answer
+func (s *Supervisor) decideMerge(a *state.Approval) {
+	if a.Status != state.ApprovalApproved {
+		if err := s.spawner.DiscardTask(a.TaskID); err != nil {
+			s.logger.Error("Failed to discard task patch", "task", a.TaskID, "error", err)
+			s.mm.Notify(a.ThreadID, fmt.Sprintf("Could not discard the patch for task `%s`: %v", a.TaskID, err))
+			return
+		}
+		s.tellManager(fmt.Sprintf("[Harness] The director declined to merge task %s; its patch was discarded.", a.TaskID))
+		return
+	}
+	if err := s.spawner.ApplyTask(a.TaskID); err != nil {
+		s.logger.Error("Failed to apply task patch", "task", a.TaskID, "error", err)
+		s.mm.Notify(a.ThreadID, fmt.Sprintf("Could not apply the patch for task `%s`: %v", a.TaskID, err))
+		s.tellManager(fmt.Sprintf("[Harness] Merging task %s was approved but the patch failed to apply: %v", a.TaskID, err))
+		return
+	}
+	s.tellManager(fmt.Sprintf("[Harness] Task %s was merged back into its project by %s.", a.TaskID, a.DecidedBy))
+}
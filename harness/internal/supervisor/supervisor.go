@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// checkInterval is how often worker activity is compared to the stuck timeout
+const checkInterval = 30 * time.Second
+
+// Supervisor restarts workers that stop producing output and enforces
+// per-task token budgets
+type Supervisor struct {
+	state     *state.AppState
+	spawner   *spawner.Spawner
+	approvals *approval.Broker
+	mm        *mattermost.Bridge
+	logger    *log.Logger
+
+	mu            sync.Mutex
+	lastMessage   map[string]string // worker ID -> last counted message ID
+	notifyManager func(string) error
+	budgetMu      sync.Mutex
+}
+
+// New creates a worker supervisor and hooks it into worker output, ready
+// patches and budget and merge-back approvals
+func New(appState *state.AppState, sp *spawner.Spawner, approvals *approval.Broker, mm *mattermost.Bridge, logger *log.Logger) *Supervisor {
+	s := &Supervisor{
+		state:       appState,
+		spawner:     sp,
+		approvals:   approvals,
+		mm:          mm,
+		logger:      logger,
+		lastMessage: make(map[string]string),
+	}
+	sp.OnOutput(s.HandleOutput)
+	sp.OnPatchReady(s.requestMerge)
+	approvals.Register(KindBudget, s.decideBudget)
+	approvals.Register(KindMerge, s.decideMerge)
+	return s
+}
+
+// SetManagerNotifier sets how supervision events are reported to the
+// manager agent
+func (s *Supervisor) SetManagerNotifier(fn func(string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifyManager = fn
+}
+
+// Run periodically checks running workers for stalls
+func (s *Supervisor) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkStuck(ctx)
+		}
+	}
+}
+
+func (s *Supervisor) checkStuck(ctx context.Context) {
+	cfg := s.state.Config().Supervision
+	timeout := time.Duration(cfg.StuckTimeoutMinutes) * time.Minute
+
+	for _, w := range s.state.ListWorkers() {
+		// Hijacked workers are being driven by the director; silence is expected
+		if w.Status != state.WorkerRunning {
+			continue
+		}
+		idle := time.Since(w.LastOutput)
+		if idle < timeout {
+			continue
+		}
+		s.handleStuck(ctx, w.ID, idle, cfg.MaxSpawnRetries)
+	}
+}
+
+func (s *Supervisor) handleStuck(ctx context.Context, id string, idle time.Duration, maxRetries int) {
+	var w state.Worker
+	s.state.UpdateWorker(id, func(sw *state.Worker) {
+		sw.Status = state.WorkerStuck
+		w = *sw
+	})
+	s.logger.Warn("Worker stuck", "worker", id, "idle", idle.Round(time.Second), "spawn_count", w.SpawnCount)
+
+	retries := w.SpawnCount - 1
+	if retries >= maxRetries {
+		msg := fmt.Sprintf("Worker `%s` produced no output for %s and has been respawned %d times. Giving up; its HANDOFF.md is left in place for review.",
+			id, idle.Round(time.Minute), retries)
+		var handoff []byte
+		if w.WorktreePath != "" && s.mm != nil && w.ThreadID != "" {
+			handoff, _ = os.ReadFile(filepath.Join(w.WorktreePath, "HANDOFF.md"))
+		}
+		if handoff == nil {
+			s.mm.Notify(w.ThreadID, msg)
+		} else {
+			s.mm.PostFile("", w.ThreadID, msg, "HANDOFF.md", handoff)
+		}
+		if err := s.spawner.Kill(id); err != nil {
+			s.logger.Error("Failed to kill stuck worker", "worker", id, "error", err)
+		}
+		s.tellManager(fmt.Sprintf("[Harness] Worker %s stalled after %d respawns and was stopped; its HANDOFF.md is in task %s.", id, retries, w.TaskID))
+		return
+	}
+
+	s.mm.Notify(w.ThreadID, fmt.Sprintf("Worker `%s` produced no output for %s. Killing it and respawning from HANDOFF.md (attempt %d of %d).",
+		id, idle.Round(time.Minute), retries+1, maxRetries))
+
+	if _, err := s.spawner.Respawn(ctx, id, spawner.HandoffPrompt(&w, "stopped producing output and was restarted by the harness")); err != nil {
+		s.logger.Error("Failed to respawn stuck worker", "worker", id, "error", err)
+		s.mm.Notify(w.ThreadID, fmt.Sprintf("Respawn of worker `%s` failed: %v", id, err))
+	}
+}
+
+func (s *Supervisor) tellManager(text string) {
+	s.mu.Lock()
+	fn := s.notifyManager
+	s.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	if err := fn(text); err != nil {
+		s.logger.Warn("Could not notify manager", "error", err)
+	}
+}
@@ -3,53 +3,159 @@ package tui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/manager"
+	"github.com/netfoundry/workspace-agent/harness/internal/privilege"
 	"github.com/netfoundry/workspace-agent/harness/internal/state"
 )
 
 // Model is the main TUI dashboard model
 type Model struct {
-	state    *state.AppState
-	logger   *log.Logger
-	width    int
-	height   int
-	selected int
-	quitting bool
+	state      *state.AppState
+	privileges *privilege.Service
+	manager    *manager.Manager
+	logger     *log.Logger
+	width      int
+	height     int
+	selected   int
+	// grantFocus moves navigation from the workers table to active grants
+	grantFocus    bool
+	grantSelected int
+	notice        string
+	// composing captures keys into draft, a message for the manager
+	composing bool
+	draft     string
+	quitting  bool
 }
 
-func newModel(appState *state.AppState, logger *log.Logger) Model {
+func newModel(appState *state.AppState, privileges *privilege.Service, mgr *manager.Manager, logger *log.Logger) Model {
 	return Model{
-		state:  appState,
-		logger: logger,
+		state:      appState,
+		privileges: privileges,
+		manager:    mgr,
+		logger:     logger,
 	}
 }
 
+// revokedMsg reports the outcome of a revocation started from the TUI
+type revokedMsg struct {
+	id  string
+	err error
+}
+
+func (m Model) activeGrants() []*state.Grant {
+	var grants []*state.Grant
+	for _, g := range m.state.ListGrants() {
+		if g.Status == state.GrantActive {
+			grants = append(grants, g)
+		}
+	}
+	return grants
+}
+
+func (m Model) revoke(id string) tea.Cmd {
+	return func() tea.Msg {
+		return revokedMsg{id: id, err: m.privileges.Revoke(id, "tui")}
+	}
+}
+
+// refreshInterval is how often the dashboard re-reads state, so worker
+// transitions made by the harness show up without a key press
+const refreshInterval = time.Second
+
+type tickMsg time.Time
+
+func tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
 func (m Model) Init() tea.Cmd {
-	return nil
+	return tick()
+}
+
+// compose handles keys while a manager message is being typed
+func (m Model) compose(msg tea.KeyMsg) Model {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.composing, m.draft = false, ""
+	case tea.KeyEnter:
+		if text := strings.TrimSpace(m.draft); text != "" && m.manager != nil {
+			m.manager.Enqueue(manager.Input{Source: manager.SourceTUI, Text: text})
+			m.notice = "Message queued for the manager."
+		}
+		m.composing, m.draft = false, ""
+	case tea.KeyBackspace:
+		if r := []rune(m.draft); len(r) > 0 {
+			m.draft = string(r[:len(r)-1])
+		}
+	case tea.KeySpace:
+		m.draft += " "
+	case tea.KeyRunes:
+		m.draft += string(msg.Runes)
+	}
+	return m
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.composing {
+			return m.compose(msg), nil
+		}
 		switch msg.String() {
+		case "m":
+			m.composing = true
 		case "q", "ctrl+c":
 			m.quitting = true
 			return m, tea.Quit
+		case "tab":
+			m.grantFocus = !m.grantFocus
 		case "j", "down":
+			if m.grantFocus {
+				if m.grantSelected < len(m.activeGrants())-1 {
+					m.grantSelected++
+				}
+				break
+			}
 			workers := m.state.ListWorkers()
 			if m.selected < len(workers)-1 {
 				m.selected++
 			}
 		case "k", "up":
+			if m.grantFocus {
+				if m.grantSelected > 0 {
+					m.grantSelected--
+				}
+				break
+			}
 			if m.selected > 0 {
 				m.selected--
 			}
+		case "r":
+			grants := m.activeGrants()
+			if m.grantFocus && m.privileges != nil && m.grantSelected < len(grants) {
+				g := grants[m.grantSelected]
+				m.notice = fmt.Sprintf("Revoking %s (%s)...", g.ID, g.PrivilegeID)
+				return m, m.revoke(g.ID)
+			}
+		}
+	case revokedMsg:
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("Revoke %s failed: %v", msg.id, msg.err)
+		} else {
+			m.notice = fmt.Sprintf("Grant %s revoked.", msg.id)
+		}
+		if n := len(m.activeGrants()); m.grantSelected >= n && n > 0 {
+			m.grantSelected = n - 1
 		}
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
+	case tickMsg:
+		return m, tick()
 	}
 	return m, nil
 }
@@ -94,19 +200,42 @@ func (m Model) View() string {
 	if len(workers) == 0 {
 		b.WriteString("  No active workers.\n")
 	} else {
-		b.WriteString("  ID            Project    Type       Status     Tokens\n")
-		b.WriteString("  ─────────────────────────────────────────────────────\n")
+		b.WriteString("  ID            Project    Type       Status     Egress     Tokens\n")
+		b.WriteString("  ────────────────────────────────────────────────────────────────\n")
 		for i, w := range workers {
 			cursor := "  "
-			if i == m.selected {
+			if i == m.selected && !m.grantFocus {
 				cursor = "▸ "
 			}
 			id := w.ID
 			if len(id) > 12 {
 				id = id[:12]
 			}
-			b.WriteString(fmt.Sprintf("%s%-14s %-10s %-10s %-10s %d\n",
-				cursor, id, w.Project, w.WorkerType, w.Status, w.TokenCount,
+			egress := string(w.Egress)
+			if egress == "" {
+				egress = "-"
+			}
+			b.WriteString(fmt.Sprintf("%s%-14s %-10s %-10s %-10s %-10s %d\n",
+				cursor, id, w.Project, w.WorkerType, w.Status, egress, w.TokenCount,
+			))
+		}
+	}
+
+	// Active privilege grants
+	if grants := m.activeGrants(); len(grants) > 0 {
+		b.WriteString("\n  Grant   Privilege             Scope      Project / Task         By\n")
+		b.WriteString("  ──────────────────────────────────────────────────────────────────────\n")
+		for i, g := range grants {
+			cursor := "  "
+			if i == m.grantSelected && m.grantFocus {
+				cursor = "▸ "
+			}
+			target := g.Project
+			if g.TaskID != "" {
+				target = g.TaskID
+			}
+			b.WriteString(fmt.Sprintf("%s%-7s %-21s %-10s %-22s %s\n",
+				cursor, g.ID, g.PrivilegeID, g.Scope, target, g.GrantedBy,
 			))
 		}
 	}
@@ -118,14 +247,48 @@ func (m Model) View() string {
 	} else {
 		b.WriteString("not running")
 	}
+	if m.manager != nil {
+		if n := m.manager.QueuedInputs(); n > 0 {
+			b.WriteString(fmt.Sprintf(" (%d queued)", n))
+		}
+	}
+	stats := m.state.ManagerStats()
+	b.WriteString(fmt.Sprintf("  Tokens: %d  Turns: %d  Tools: %d", stats.Tokens, stats.Turns, stats.ToolCalls))
+	if stats.LastTool != "" {
+		b.WriteString(" (last " + stats.LastTool + ")")
+	}
+	if stats.LastError != "" {
+		b.WriteString("\n  Last manager turn failed: " + stats.LastError)
+	}
+	if h := m.state.ManagerHealth(); h.Degraded {
+		b.WriteString(fmt.Sprintf("\n  Manager DEGRADED: %d quick exits in a row", h.Crashes))
+		if h.LastError != "" {
+			b.WriteString(" (" + h.LastError + ")")
+		}
+		for _, line := range h.StderrTail {
+			b.WriteString("\n    " + line)
+		}
+	}
+	if h := m.state.ManagerHealth(); h.AnnounceError != "" {
+		b.WriteString("\n  Manager health notice not posted to Mattermost: " + h.AnnounceError)
+	}
+
+	if m.notice != "" {
+		b.WriteString("\n\n  " + m.notice)
+	}
+
+	if m.composing {
+		b.WriteString("\n\n  To manager: " + m.draft + "█\n  enter: send  esc: cancel\n")
+		return b.String()
+	}
 
-	b.WriteString("\n\n  q: quit  j/k: navigate\n")
+	b.WriteString("\n\n  q: quit  j/k: navigate  tab: workers/grants  r: revoke grant  m: message manager\n")
 	return b.String()
 }
 
 // Run starts the Bubble Tea TUI
-func Run(appState *state.AppState, logger *log.Logger) error {
-	m := newModel(appState, logger)
+func Run(appState *state.AppState, privileges *privilege.Service, mgr *manager.Manager, logger *log.Logger) error {
+	m := newModel(appState, privileges, mgr, logger)
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	_, err := p.Run()
 	return err
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// identity returns the user and groups the auth gate in front of the web
+// UI asserted for a request. Requests that did not come through a trusted
+// gate have no identity, whatever headers they carry.
+func (s *Server) identity(r *http.Request) (string, []string) {
+	access := s.state.Config().Access
+	if !access.TrustsProxy(r.RemoteAddr) {
+		return "", nil
+	}
+	user := strings.TrimSpace(r.Header.Get(access.UserHeader))
+	var groups []string
+	for _, g := range strings.Split(r.Header.Get(access.GroupsHeader), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return user, groups
+}
+
+// actor names who made a change through the web UI, for grants and
+// approval records
+func (s *Server) actor(r *http.Request) string {
+	if user, _ := s.identity(r); user != "" {
+		return user + " (web-ui)"
+	}
+	return "web-ui"
+}
+
+// requiredRole is the role a request needs: observers may look, approvers
+// may decide approvals, everything else that changes state is for directors
+func requiredRole(r *http.Request) state.Role {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return state.RoleObserver
+	case strings.HasPrefix(r.URL.Path, "/api/approvals/"):
+		return state.RoleApprover
+	default:
+		return state.RoleDirector
+	}
+}
+
+// authorize enforces the access rules in workspace.yaml on every request
+// except static assets and Mattermost callbacks, which carry their own
+// token and are checked against the Mattermost user
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		access := s.state.Config().Access
+		if !access.Enforced() || strings.HasPrefix(r.URL.Path, "/static/") || strings.HasPrefix(r.URL.Path, "/api/mattermost/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, groups := s.identity(r)
+		role := requiredRole(r)
+		if !access.Allows(role, user, groups) {
+			s.logger.Warn("Unauthorized web request", "user", user, "groups", strings.Join(groups, ","), "method", r.Method, "path", r.URL.Path, "role", role)
+			http.Error(w, "Sorry, you are not authorized to do that (requires the "+string(role)+" role).", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
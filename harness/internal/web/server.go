@@ -6,12 +6,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 
 	"github.com/charmbracelet/log"
 	"github.com/gorilla/websocket"
+	"github.com/netfoundry/workspace-agent/harness/internal/approval"
+	"github.com/netfoundry/workspace-agent/harness/internal/manager"
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/privilege"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
 	"github.com/netfoundry/workspace-agent/harness/internal/state"
+	"github.com/netfoundry/workspace-agent/harness/internal/supervisor"
 )
 
 //go:embed templates/*.html
@@ -28,40 +36,85 @@ var upgrader = websocket.Upgrader{
 type Server struct {
 	port   int
 	state  *state.AppState
+	spawner *spawner.Spawner
+	approvals *approval.Broker
+	privileges *privilege.Service
+	manager *manager.Manager
+	supervisor *supervisor.Supervisor
 	logger *log.Logger
 	tmpl   *template.Template
+	ctx    context.Context
 	wsClients map[*websocket.Conn]bool
 	wsMu      sync.Mutex
+
+	slashMu    sync.Mutex
+	slashToken string
+	mm         *mattermost.Bridge // resolves slash command users
+
+	hosts []string // addresses to listen on; every interface when empty
 }
 
 // NewServer creates a new web server
-func NewServer(port int, appState *state.AppState, logger *log.Logger) *Server {
+func NewServer(port int, appState *state.AppState, sp *spawner.Spawner, approvals *approval.Broker, privileges *privilege.Service, mgr *manager.Manager, sup *supervisor.Supervisor, logger *log.Logger) *Server {
 	tmpl, err := template.ParseFS(templateFS, "templates/*.html")
 	if err != nil {
 		logger.Warn("Failed to parse templates (will use fallback)", "error", err)
 	}
-	return &Server{
+	s := &Server{
 		port:      port,
 		state:     appState,
+		spawner:   sp,
+		approvals: approvals,
+		privileges: privileges,
+		manager:   mgr,
+		supervisor: sup,
 		logger:    logger,
+		ctx:       context.Background(),
 		tmpl:      tmpl,
 		wsClients: make(map[*websocket.Conn]bool),
 	}
+	sp.OnChange(func(event, workerID string) {
+		s.Broadcast(map[string]string{"event": event, "worker": workerID})
+	})
+	return s
+}
+
+// SetListenHosts restricts the server to the given addresses, so it is
+// not reachable from the worker sandbox network the harness also joins
+func (s *Server) SetListenHosts(hosts ...string) {
+	s.hosts = hosts
 }
 
 // Run starts the HTTP server
 func (s *Server) Run(ctx context.Context) {
+	s.ctx = ctx
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleDashboard)
 	mux.HandleFunc("/api/status", s.handleAPIStatus)
+	mux.HandleFunc("POST /api/manager/messages", s.handleAPIManagerMessage)
 	mux.HandleFunc("/api/workers", s.handleAPIWorkers)
+	mux.HandleFunc("POST /api/workers/{id}/kill", s.handleAPIKillWorker)
+	mux.HandleFunc("/api/tasks", s.handleAPITasks)
+	mux.HandleFunc("POST /api/tasks/{id}/complete", s.handleAPICompleteTask)
+	mux.HandleFunc("POST /api/tasks/{id}/archive", s.handleAPIArchiveTask)
+	mux.HandleFunc("GET /api/tasks/{id}/patch", s.handleAPITaskPatch)
+	mux.HandleFunc("POST /api/tasks/{id}/snapshot", s.handleAPISnapshotTask)
+	mux.HandleFunc("POST /api/tasks/{id}/apply", s.handleAPIApplyTask)
+	mux.HandleFunc("POST /api/tasks/{id}/discard", s.handleAPIDiscardTask)
 	mux.HandleFunc("/api/resources", s.handleAPIResources)
+	mux.HandleFunc("/api/approvals", s.handleAPIApprovals)
+	mux.HandleFunc("/api/egress", s.handleAPIEgress)
+	mux.HandleFunc("/api/grants", s.handleAPIGrants)
+	mux.HandleFunc("POST /api/grants/{id}/revoke", s.handleAPIRevokeGrant)
+	mux.HandleFunc("POST /api/approvals/{id}/{decision}", s.handleAPIDecideApproval)
+	mux.HandleFunc("POST /api/mattermost/command", s.handleSlashCommand)
+	mux.HandleFunc("POST "+approval.ActionPath, s.handleAction)
 	mux.HandleFunc("/ws", s.handleWebSocket)
 	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: mux,
+		Handler: s.authorize(mux),
 	}
 
 	go func() {
@@ -69,10 +122,31 @@ func (s *Server) Run(ctx context.Context) {
 		srv.Close()
 	}()
 
-	s.logger.Info("Web UI listening", "port", s.port)
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		s.logger.Error("Web server error", "error", err)
+	if len(s.hosts) == 0 {
+		s.logger.Info("Web UI listening", "port", s.port)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			s.logger.Error("Web server error", "error", err)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, host := range s.hosts {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(s.port)))
+		if err != nil {
+			s.logger.Error("Web server error", "host", host, "error", err)
+			continue
+		}
+		s.logger.Info("Web UI listening", "addr", ln.Addr())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Serve(ln); err != http.ErrServerClosed {
+				s.logger.Error("Web server error", "error", err)
+			}
+		}()
 	}
+	wg.Wait()
 }
 
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +160,14 @@ func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
 		"TrafficLight": s.state.TrafficLightStatus(),
 		"Resources":    s.state.LatestResource(),
 		"ManagerPID":   s.state.ManagerPID(),
+		"ManagerQueue": s.manager.QueuedInputs(),
+		"Manager":      s.state.ManagerStats(),
+		"Health":       s.state.ManagerHealth(),
+		"Projects":     s.state.Config().Projects,
+		"Tasks":        s.state.ListTasks(),
+		"Approvals":    s.state.PendingApprovals(""),
+		"Egress":       recentEgress(s.state.EgressLog("")),
+		"Grants":       recentGrants(s.state.ListGrants()),
 	}
 	if err := s.tmpl.ExecuteTemplate(w, "dashboard.html", data); err != nil {
 		s.logger.Error("Template error", "error", err)
@@ -97,17 +179,247 @@ func (s *Server) handleAPIStatus(w http.ResponseWriter, r *http.Request) {
 	status := map[string]interface{}{
 		"traffic_light": s.state.TrafficLightStatus(),
 		"manager_pid":   s.state.ManagerPID(),
+		"manager_queue": s.manager.QueuedInputs(),
+		"manager":       s.state.ManagerStats(),
+		"manager_health": s.state.ManagerHealth(),
 		"worker_count":  len(s.state.ListWorkers()),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
+// handleAPIManagerMessage queues a message from the web UI for the manager
+// agent; it is delivered once the manager is running
+func (s *Server) handleAPIManagerMessage(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text     string `json:"text"`
+		ThreadID string `json:"thread_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Text == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+	user, _ := s.identity(r)
+	s.manager.Enqueue(manager.Input{
+		Source:   manager.SourceWeb,
+		ThreadID: body.ThreadID,
+		User:     user,
+		Text:     body.Text,
+	})
+	s.Broadcast(map[string]string{"event": "manager_message_queued"})
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (s *Server) handleAPIWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.handleAPISpawnWorker(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s.state.ListWorkers())
 }
 
+// handleAPISpawnWorker lets the director spawn a worker for a project alias
+func (s *Server) handleAPISpawnWorker(w http.ResponseWriter, r *http.Request) {
+	var req spawner.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	worker, err := s.spawner.Spawn(r.Context(), req)
+	if err != nil {
+		s.logger.Error("Spawn from web UI failed", "project", req.Project, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "worker_spawned", "worker": worker.ID})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(worker)
+}
+
+func (s *Server) handleAPIKillWorker(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.spawner.Kill(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "worker_killed", "worker": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAPITasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.state.ListTasks())
+}
+
+// handleAPICompleteTask marks a task done, expiring its per-task grants
+func (s *Server) handleAPICompleteTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.spawner.CompleteTask(id); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "task_completed", "task": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIArchiveTask prunes a task's workspace; ?force=true discards
+// uncommitted changes
+func (s *Server) handleAPIArchiveTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	force := r.URL.Query().Get("force") == "true"
+	if err := s.spawner.ArchiveTask(id, force); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "task_archived", "task": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPITaskPatch serves a copy-isolated task's stored changeset. It
+// never snapshots; POST .../snapshot takes a fresh one.
+func (s *Server) handleAPITaskPatch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	task := s.state.GetTask(id)
+	if task == nil {
+		http.Error(w, "unknown task", http.StatusNotFound)
+		return
+	}
+	if task.Patch == "" {
+		http.Error(w, "no changes", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	http.ServeFile(w, r, task.Patch)
+}
+
+// handleAPISnapshotTask writes a fresh patch of a copy-isolated task, e.g.
+// to review it while workers are still running
+func (s *Server) handleAPISnapshotTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	stat, err := s.spawner.SnapshotTask(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := s.state.Save(); err != nil {
+		s.logger.Warn("Failed to persist state after snapshot", "error", err)
+	}
+	s.Broadcast(map[string]string{"event": "task_snapshot", "task": id})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"stat": stat})
+}
+
+func (s *Server) handleAPIApplyTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.spawner.ApplyTask(id); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "task_patch_applied", "task": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAPIDiscardTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.spawner.DiscardTask(id); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "task_patch_discarded", "task": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIApprovals lists pending approvals; ?all=true includes decided
+// ones, including those decided by policy, for audit
+func (s *Server) handleAPIApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("all") == "true" {
+		json.NewEncoder(w).Encode(s.state.ListApprovals())
+		return
+	}
+	json.NewEncoder(w).Encode(s.state.PendingApprovals(""))
+}
+
+// handleAPIEgress lists requests workers made through the egress proxy,
+// newest first; ?worker=<id> narrows it to one worker
+func (s *Server) handleAPIEgress(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.state.EgressLog(r.URL.Query().Get("worker")))
+}
+
+// dashboardEgressRows is how many proxy requests the dashboard shows
+const dashboardEgressRows = 25
+
+func recentEgress(log []state.EgressRequest) []state.EgressRequest {
+	if len(log) > dashboardEgressRows {
+		return log[:dashboardEgressRows]
+	}
+	return log
+}
+
+// handleAPIGrants returns the privilege grant ledger, newest first
+func (s *Server) handleAPIGrants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.state.ListGrants())
+}
+
+// handleAPIRevokeGrant revokes an active grant and tears down its access
+func (s *Server) handleAPIRevokeGrant(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := s.privileges.Revoke(id, s.actor(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "grant_revoked", "grant": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dashboardGrantRows is how many ended grants the dashboard shows beside
+// the active ones
+const dashboardGrantRows = 25
+
+func recentGrants(grants []*state.Grant) []*state.Grant {
+	var result []*state.Grant
+	ended := 0
+	for _, g := range grants {
+		if g.Status != state.GrantActive {
+			if ended >= dashboardGrantRows {
+				continue
+			}
+			ended++
+		}
+		result = append(result, g)
+	}
+	return result
+}
+
+// handleAPIDecideApproval records the director's decision on a pending
+// approval; decision is "approve" or "deny"
+func (s *Server) handleAPIDecideApproval(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var approved bool
+	switch r.PathValue("decision") {
+	case "approve":
+		approved = true
+	case "deny":
+	default:
+		http.Error(w, "decision must be approve or deny", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Amount int64 `json:"amount"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if err := s.approvals.Decide(id, approved, s.actor(r), body.Amount); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.Broadcast(map[string]string{"event": "approval_decided", "approval": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (s *Server) handleAPIResources(w http.ResponseWriter, r *http.Request) {
 	snap := s.state.LatestResource()
 	w.Header().Set("Content-Type", "application/json")
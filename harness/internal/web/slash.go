@@ -0,0 +1,363 @@
+package web
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/netfoundry/workspace-agent/harness/internal/mattermost"
+	"github.com/netfoundry/workspace-agent/harness/internal/spawner"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+// Slash command response types
+const (
+	responseEphemeral = "ephemeral"  // only the caller sees it
+	responseInChannel = "in_channel" // posted where the command was run
+)
+
+// respondTimeout bounds a delayed answer to a slash command's response URL
+const respondTimeout = 10 * time.Second
+
+var respondClient = &http.Client{Timeout: respondTimeout}
+
+const slashUsage = "Usage: `/agent status` · `/agent workers` · `/agent kill <worker>` · " +
+	"`/agent spawn <project> <frontier|local> <prompt>` · `/agent budget <task> +N` · `/agent grant <task> <privilege|allow:domain>`"
+
+// slashResponse is the body Mattermost expects from a slash command
+type slashResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// slashCommand is one /agent invocation as posted by Mattermost. Slash
+// commands carry no thread, so commands that act on a task name it.
+type slashCommand struct {
+	UserID      string
+	User        string // username resolved from UserID
+	ChannelID   string
+	ResponseURL string
+	Args        []string
+}
+
+// SetSlashToken sets the token Mattermost sends with /agent commands.
+// Without one the endpoint refuses every command.
+func (s *Server) SetSlashToken(token string) {
+	s.slashMu.Lock()
+	defer s.slashMu.Unlock()
+	s.slashToken = token
+}
+
+// SetMattermost sets the bridge used to resolve who ran a slash command
+// and to open threads for spawned workers. Without one the endpoint
+// refuses every command.
+func (s *Server) SetMattermost(b *mattermost.Bridge) {
+	s.slashMu.Lock()
+	defer s.slashMu.Unlock()
+	s.mm = b
+}
+
+// handleSlashCommand serves the /agent slash command. Mattermost posts the
+// command here; answers are built from AppState, not the manager.
+func (s *Server) handleSlashCommand(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.slashMu.Lock()
+	token, mm := s.slashToken, s.mm
+	s.slashMu.Unlock()
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(token)) != 1 {
+		s.logger.Warn("Rejected slash command with a bad token", "user_id", r.PostForm.Get("user_id"))
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	cmd := slashCommand{
+		UserID:      r.PostForm.Get("user_id"),
+		ChannelID:   r.PostForm.Get("channel_id"),
+		ResponseURL: r.PostForm.Get("response_url"),
+		Args:        strings.Fields(r.PostForm.Get("text")),
+	}
+	var resp slashResponse
+	// Roles are only granted to the username Mattermost resolves for the
+	// user ID; the form's user_name is never used
+	if u, err := resolveUser(mm, cmd.UserID); err != nil {
+		s.logger.Warn("Could not resolve slash command user; refusing", "user_id", cmd.UserID, "error", err)
+		resp = ephemeral("Sorry, I could not verify who you are. Try again later.")
+	} else {
+		cmd.User = u.Username
+		s.logger.Info("Slash command", "user", cmd.User, "text", r.PostForm.Get("text"))
+		resp = s.runSlashCommand(mm, cmd)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// resolveUser looks up the user who ran a command by ID
+func resolveUser(mm *mattermost.Bridge, userID string) (*mattermost.User, error) {
+	if mm == nil {
+		return nil, errors.New("mattermost is not configured")
+	}
+	if userID == "" {
+		return nil, errors.New("no user ID")
+	}
+	return mm.Users().User(userID)
+}
+
+// slashRole is the role a subcommand needs
+func slashRole(sub string) state.Role {
+	switch sub {
+	case "status", "workers", "help":
+		return state.RoleObserver
+	case "budget":
+		return state.RoleApprover
+	}
+	return state.RoleDirector
+}
+
+func (s *Server) runSlashCommand(mm *mattermost.Bridge, cmd slashCommand) slashResponse {
+	if len(cmd.Args) == 0 {
+		return ephemeral(slashUsage)
+	}
+	sub, args := strings.ToLower(cmd.Args[0]), cmd.Args[1:]
+
+	role := slashRole(sub)
+	msg := mattermost.Message{UserID: cmd.UserID, Username: cmd.User, ChannelID: cmd.ChannelID}
+	if !mm.Authorized(msg, role) {
+		s.logger.Warn("Unauthorized slash command", "user", cmd.User, "command", sub, "role", role)
+		return ephemeral(fmt.Sprintf("Sorry @%s, `/agent %s` needs the %s role. Ask a workspace director to add you in workspace.yaml.", cmd.User, sub, role))
+	}
+
+	switch sub {
+	case "status":
+		return ephemeral(s.slashStatus())
+	case "workers":
+		return ephemeral(s.slashWorkers())
+	case "kill":
+		return s.slashKill(cmd, args)
+	case "spawn":
+		return s.slashSpawn(mm, cmd, args)
+	case "budget":
+		return s.slashBudget(cmd, args)
+	case "grant":
+		return s.slashGrant(cmd, args)
+	default:
+		return ephemeral(slashUsage)
+	}
+}
+
+func (s *Server) slashStatus() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Workspace status** · API usage: %s\n", s.state.TrafficLightStatus())
+
+	if pid := s.state.ManagerPID(); pid > 0 {
+		fmt.Fprintf(&b, "Manager: running (PID %d)", pid)
+	} else {
+		b.WriteString("Manager: not running")
+	}
+	if h := s.state.ManagerHealth(); h.Degraded {
+		fmt.Fprintf(&b, " · **degraded** (%d quick exits)", h.Crashes)
+	}
+	if n := s.manager.QueuedInputs(); n > 0 {
+		fmt.Fprintf(&b, " · %d queued", n)
+	}
+	b.WriteString("\n")
+
+	active := 0
+	for _, w := range s.state.ListWorkers() {
+		if w.IsActive() {
+			active++
+		}
+	}
+	tasks := 0
+	for _, t := range s.state.ListTasks() {
+		if t.Status == state.TaskActive {
+			tasks++
+		}
+	}
+	fmt.Fprintf(&b, "Workers: %d active · Tasks: %d active · Pending approvals: %d", active, tasks, len(s.state.PendingApprovals("")))
+	if snap := s.state.LatestResource(); snap != nil {
+		fmt.Fprintf(&b, "\nCPU %.1f%% · RAM %d/%d MB · GPU %.1f%%", snap.CPUPercent, snap.RAMUsedMB, snap.RAMTotalMB, snap.GPUPercent)
+	}
+	return b.String()
+}
+
+func (s *Server) slashWorkers() string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPROJECT\tTYPE\tSTATUS\tTASK\tTOKENS")
+	n := 0
+	for _, w := range s.state.ListWorkers() {
+		if !w.IsActive() {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", w.ID, w.Project, w.WorkerType, w.Status, w.TaskID, w.TokenCount)
+		n++
+	}
+	if n == 0 {
+		return "No active workers."
+	}
+	tw.Flush()
+	return "```\n" + buf.String() + "```"
+}
+
+func (s *Server) slashKill(cmd slashCommand, args []string) slashResponse {
+	if len(args) != 1 {
+		return ephemeral("Usage: `/agent kill <worker>`")
+	}
+	if err := s.spawner.Kill(args[0]); err != nil {
+		return ephemeral(fmt.Sprintf("Could not kill `%s`: %v", args[0], err))
+	}
+	s.Broadcast(map[string]string{"event": "worker_killed", "worker": args[0]})
+	return inChannel(fmt.Sprintf("Worker `%s` killed by @%s.", args[0], cmd.User))
+}
+
+// parseSpawn reads `/agent spawn` arguments: a project, a worker type and
+// the prompt
+func parseSpawn(args []string) (spawner.Request, error) {
+	if len(args) < 3 {
+		return spawner.Request{}, errors.New("Usage: `/agent spawn <project> <frontier|local> <prompt>`")
+	}
+	req := spawner.Request{
+		Project:    args[0],
+		WorkerType: strings.ToLower(args[1]),
+		Prompt:     strings.Join(args[2:], " "),
+	}
+	if req.WorkerType != "frontier" && req.WorkerType != "local" {
+		return spawner.Request{}, fmt.Errorf("Unknown worker type `%s`; use `frontier` or `local`.", args[1])
+	}
+	return req, nil
+}
+
+// slashSpawn starts a worker in the background, since a spawn can take
+// longer than Mattermost waits for a command, and reports through the
+// command's response URL. Slash commands carry no thread, so the worker
+// gets a new one in the command's channel to report and ask in.
+func (s *Server) slashSpawn(mm *mattermost.Bridge, cmd slashCommand, args []string) slashResponse {
+	req, err := parseSpawn(args)
+	if err != nil {
+		return ephemeral(err.Error())
+	}
+	if s.state.Config().FindProject(req.Project) == nil {
+		return ephemeral(fmt.Sprintf("Unknown project `%s`.", req.Project))
+	}
+	go func() {
+		resp := s.spawnInThread(mm, cmd, req)
+		s.respondLater(cmd.ResponseURL, resp)
+	}()
+	return ephemeral(fmt.Sprintf("Spawning a %s worker for `%s`…", req.WorkerType, req.Project))
+}
+
+// spawnInThread opens the worker's thread with the request and spawns it
+// there
+func (s *Server) spawnInThread(mm *mattermost.Bridge, cmd slashCommand, req spawner.Request) slashResponse {
+	d := mm.PostMessage(cmd.ChannelID, "", fmt.Sprintf("@%s asked for a %s worker on `%s`:\n\n%s", cmd.User, req.WorkerType, req.Project, req.Prompt))
+	if err := d.Wait(s.ctx); err != nil {
+		s.logger.Error("Failed to open a thread for a spawned worker", "project", req.Project, "error", err)
+		return ephemeral(fmt.Sprintf("Could not open a thread for the %s worker on `%s`: %v", req.WorkerType, req.Project, err))
+	}
+	req.ThreadID = d.PostID()
+
+	w, err := s.spawner.Spawn(s.ctx, req)
+	if err != nil {
+		s.logger.Error("Failed to spawn worker", "project", req.Project, "error", err)
+		mm.Notify(req.ThreadID, fmt.Sprintf("Failed to spawn the worker: %v", err))
+		return ephemeral(fmt.Sprintf("Failed to spawn %s worker for `%s`: %v", req.WorkerType, req.Project, err))
+	}
+	s.Broadcast(map[string]string{"event": "worker_spawned", "worker": w.ID})
+	mm.Notify(req.ThreadID, fmt.Sprintf("Spawned worker `%s` (task `%s`). It reports in this thread.", w.ID, w.TaskID))
+	return inChannel(fmt.Sprintf("Spawned %s worker `%s` for `%s` (task `%s`), requested by @%s.", w.WorkerType, w.ID, w.Project, w.TaskID, cmd.User))
+}
+
+// parseBudget reads `/agent budget` arguments: a task and a token count,
+// optionally written +N
+func parseBudget(args []string) (string, int64, error) {
+	if len(args) != 2 {
+		return "", 0, errors.New("Usage: `/agent budget <task> +N`")
+	}
+	amount, err := strconv.ParseInt(strings.TrimPrefix(args[1], "+"), 10, 64)
+	if err != nil || amount <= 0 {
+		return "", 0, fmt.Errorf("`%s` is not a number of tokens.", args[1])
+	}
+	return args[0], amount, nil
+}
+
+func (s *Server) slashBudget(cmd slashCommand, args []string) slashResponse {
+	taskID, amount, err := parseBudget(args)
+	if err != nil {
+		return ephemeral(err.Error())
+	}
+	if err := s.supervisor.ExtendBudget(taskID, amount, cmd.User); err != nil {
+		return ephemeral(fmt.Sprintf("Could not extend the budget of `%s`: %v", taskID, err))
+	}
+	t := s.state.GetTask(taskID)
+	return inChannel(fmt.Sprintf("Budget for task `%s` extended by %d to %d tokens by @%s.", t.ID, amount, t.TokenBudget, cmd.User))
+}
+
+func (s *Server) slashGrant(cmd slashCommand, args []string) slashResponse {
+	if len(args) != 2 {
+		return ephemeral("Usage: `/agent grant <task> <privilege|allow:domain>`")
+	}
+	g, err := s.privileges.GrantTask(args[0], args[1], cmd.User)
+	if err != nil {
+		return ephemeral(fmt.Sprintf("Could not grant `%s` to `%s`: %v", args[1], args[0], err))
+	}
+	s.Broadcast(map[string]string{"event": "grant_added", "grant": g.ID})
+	// The privilege service announces the grant in the thread
+	return ephemeral(fmt.Sprintf("Granted `%s` (grant `%s`).", g.PrivilegeID, g.ID))
+}
+
+// handleAction receives Mattermost button clicks on approval prompts
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
+	var req mattermost.ActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := s.approvals.HandleAction(req)
+	if resp.Update != nil {
+		id, _ := req.Context["approval"].(string)
+		s.Broadcast(map[string]string{"event": "approval_decided", "approval": id})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// respondLater posts a delayed answer to a slash command's response URL
+func (s *Server) respondLater(url string, resp slashResponse) {
+	if url == "" {
+		return
+	}
+	data, _ := json.Marshal(resp)
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		s.logger.Error("Failed to answer slash command", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	r, err := respondClient.Do(req)
+	if err != nil {
+		s.logger.Error("Failed to answer slash command", "error", err)
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode >= 400 {
+		s.logger.Error("Failed to answer slash command", "status", r.StatusCode)
+	}
+}
+
+func ephemeral(text string) slashResponse {
+	return slashResponse{ResponseType: responseEphemeral, Text: text}
+}
+
+func inChannel(text string) slashResponse {
+	return slashResponse{ResponseType: responseInChannel, Text: text}
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/log"
+	"github.com/netfoundry/workspace-agent/harness/internal/state"
+)
+
+func TestParseSpawn(t *testing.T) {
+	tests := []struct {
+		text    string
+		project string
+		typ     string
+		prompt  string
+		wantErr bool
+	}{
+		{text: "api frontier fix the flaky test", project: "api", typ: "frontier", prompt: "fix the flaky test"},
+		{text: "api Local  summarize   the logs", project: "api", typ: "local", prompt: "summarize the logs"},
+		{text: "api frontier", wantErr: true},
+		{text: "api", wantErr: true},
+		{text: "", wantErr: true},
+		{text: "api remote do it", wantErr: true},
+	}
+	for _, tt := range tests {
+		req, err := parseSpawn(strings.Fields(tt.text))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSpawn(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if req.Project != tt.project || req.WorkerType != tt.typ || req.Prompt != tt.prompt || req.ThreadID != "" {
+			t.Errorf("parseSpawn(%q) = %+v", tt.text, req)
+		}
+	}
+}
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		text    string
+		task    string
+		amount  int64
+		wantErr bool
+	}{
+		{text: "task1 +50000", task: "task1", amount: 50000},
+		{text: "task1 50000", task: "task1", amount: 50000},
+		{text: "task1 +0", wantErr: true},
+		{text: "task1 -100", wantErr: true},
+		{text: "task1 lots", wantErr: true},
+		{text: "task1", wantErr: true},
+		{text: "task1 +1 +2", wantErr: true},
+	}
+	for _, tt := range tests {
+		task, amount, err := parseBudget(strings.Fields(tt.text))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBudget(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (task != tt.task || amount != tt.amount) {
+			t.Errorf("parseBudget(%q) = %q, %d, want %q, %d", tt.text, task, amount, tt.task, tt.amount)
+		}
+	}
+}
+
+func TestSlashRole(t *testing.T) {
+	tests := map[string]state.Role{
+		"status":  state.RoleObserver,
+		"workers": state.RoleObserver,
+		"help":    state.RoleObserver,
+		"budget":  state.RoleApprover,
+		"kill":    state.RoleDirector,
+		"spawn":   state.RoleDirector,
+		"grant":   state.RoleDirector,
+		"unknown": state.RoleDirector,
+	}
+	for sub, want := range tests {
+		if got := slashRole(sub); got != want {
+			t.Errorf("slashRole(%q) = %s, want %s", sub, got, want)
+		}
+	}
+}
+
+func TestHandleSlashCommandRefuses(t *testing.T) {
+	s := &Server{
+		state:  state.New(&state.Config{}),
+		logger: log.New(io.Discard),
+		ctx:    context.Background(),
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/mattermost/command", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.handleSlashCommand(w, r)
+		return w
+	}
+	form := url.Values{"token": {"secret"}, "user_id": {"u1"}, "user_name": {"director"}, "text": {"status"}}
+
+	// No token configured refuses everything
+	if w := post(form); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	s.SetSlashToken("secret")
+	bad := url.Values{"token": {"wrong"}, "user_id": {"u1"}, "text": {"status"}}
+	if w := post(bad); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// The user cannot be resolved without Mattermost, whatever user_name says
+	w := post(form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("good token: status %d", w.Code)
+	}
+	var resp slashResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ResponseType != responseEphemeral || !strings.Contains(resp.Text, "could not verify") {
+		t.Errorf("unresolved user got %+v", resp)
+	}
+}
+
+func TestRespondLater(t *testing.T) {
+	got := make(chan slashResponse, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp slashResponse
+		json.NewDecoder(r.Body).Decode(&resp)
+		got <- resp
+	}))
+	defer srv.Close()
+
+	s := &Server{logger: log.New(io.Discard), ctx: context.Background()}
+	s.respondLater(srv.URL, inChannel("Spawned."))
+	select {
+	case resp := <-got:
+		if resp.ResponseType != responseInChannel || resp.Text != "Spawned." {
+			t.Errorf("response URL got %+v", resp)
+		}
+	default:
+		t.Fatal("response URL was not called")
+	}
+}